@@ -0,0 +1,338 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package durable keeps a hashmap on disk. Every write is appended to a
+// write-ahead log, which is replayed on startup, and compacted into a snapshot
+// from time to time. Reads are served by the in-memory map without any lock
+package durable
+
+import (
+	"errors"
+	"iter"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustinxie/lockfree/hashmap"
+)
+
+// SyncMode tells when the log is flushed to disk
+type SyncMode int
+
+const (
+	// SyncNone leaves flushing to the OS, writes since the last flush are lost
+	// if the machine crashes, but not if only the process does
+	SyncNone SyncMode = iota
+
+	// SyncInterval flushes the log periodically, see SyncIntervalOption
+	SyncInterval
+
+	// SyncAlways flushes the log before a write returns. Concurrent writes
+	// are flushed together
+	SyncAlways
+)
+
+// ErrClosed is returned by the writes to a closed map
+var ErrClosed = errors.New("durable: map is closed")
+
+type (
+	// Map is a hashmap backed by a write-ahead log in a directory. Writes are
+	// serialized, so they reach the log in the same order as the map
+	Map[K comparable, V any] struct {
+		options
+		m       *hashmap.Map[K, V]
+		dir     string
+		mutex   sync.Mutex     // orders writes to the map and the log buffer
+		buf     []byte         // records not yet written to the log
+		appends uint64         // number of records appended to buf
+		syncs   sync.Mutex     // held by the go-routine writing buf to the log
+		written uint64         // number of records written to the log
+		log     *os.File       // log file being appended to
+		seq     uint64         // sequence number of the log file
+		size    int64          // bytes written to the logs since the last snapshot
+		err     error          // first error writing the log, returned by all writes after it
+		closed  bool           // set by Close
+		compact sync.Mutex     // held by the go-routine taking a snapshot
+		running uint32         // 1 if a snapshot is taken in the background
+		done    chan struct{}  // closed by Close to stop the background go-routine
+		wg      sync.WaitGroup // waits for the background go-routines
+	}
+
+	options struct {
+		mode             SyncMode         // when the log is flushed to disk
+		syncInterval     time.Duration    // how often the log is flushed with SyncInterval
+		snapshotInterval time.Duration    // how often a snapshot is taken, 0 means never
+		compactSize      int64            // take a snapshot once the logs grow beyond this
+		mapOpts          []hashmap.Option // options of the in-memory map
+	}
+)
+
+// Option provides options for opening a Map
+type Option func(*options)
+
+// SyncModeOption sets when the log is flushed to disk, it defaults to
+// SyncInterval
+func SyncModeOption(mode SyncMode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// SyncIntervalOption sets how often the log is flushed to disk with
+// SyncInterval, it defaults to 1 second
+func SyncIntervalOption(d time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = d
+	}
+}
+
+// SnapshotIntervalOption takes a snapshot periodically if anything is written
+// since the last one
+func SnapshotIntervalOption(d time.Duration) Option {
+	return func(o *options) {
+		o.snapshotInterval = d
+	}
+}
+
+// CompactSizeOption takes a snapshot once the logs since the last snapshot
+// grow beyond size bytes, it defaults to 64MB. 0 disables it
+func CompactSizeOption(size int64) Option {
+	return func(o *options) {
+		o.compactSize = size
+	}
+}
+
+// MapOption sets the options of the in-memory map. Entries dropped by TTL or
+// eviction are not logged, so they may come back after a restart
+func MapOption(opts ...hashmap.Option) Option {
+	return func(o *options) {
+		o.mapOpts = append(o.mapOpts, opts...)
+	}
+}
+
+// Open opens the map in the directory, which is created if it does not exist.
+// The map is loaded from the latest snapshot and the logs after it
+func Open[K comparable, V any](dir string, opts ...Option) (*Map[K, V], error) {
+	m := Map[K, V]{
+		options: options{
+			mode:         SyncInterval,
+			syncInterval: time.Second,
+			compactSize:  64 << 20,
+		},
+		dir:  dir,
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(&m.options)
+	}
+	m.m = hashmap.NewMap[K, V](m.mapOpts...)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := m.recover(); err != nil {
+		return nil, err
+	}
+
+	if m.mode == SyncInterval || m.snapshotInterval > 0 {
+		m.wg.Add(1)
+		go m.background()
+	}
+	return &m, nil
+}
+
+func (m *Map[K, V]) Len() int {
+	return m.m.Len()
+}
+
+func (m *Map[K, V]) Get(key K) (V, bool) {
+	return m.m.Get(key)
+}
+
+// Range calls f sequentially for each key and value in the map, and stops if f
+// returns false
+func (m *Map[K, V]) Range(f func(key K, value V) bool) {
+	m.m.Range(f)
+}
+
+// All returns an iterator over the entries of the map
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return m.m.All()
+}
+
+// Set sets the value for a key. It returns once the write is logged, and
+// flushed to disk with SyncAlways
+func (m *Map[K, V]) Set(key K, value V) error {
+	rec, err := appendRecord(nil, opSet, key, value)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	if err := m.writable(); err != nil {
+		m.mutex.Unlock()
+		return err
+	}
+	m.m.Set(key, value)
+	n := m.append(rec)
+	m.mutex.Unlock()
+	return m.commit(n)
+}
+
+// Del deletes the key. It returns once the delete is logged, and flushed to
+// disk with SyncAlways
+func (m *Map[K, V]) Del(key K) error {
+	rec, err := appendRecord(nil, opDel, key, nil)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	if err := m.writable(); err != nil {
+		m.mutex.Unlock()
+		return err
+	}
+	if _, ok := m.m.LoadAndDelete(key); !ok {
+		// nothing to log
+		m.mutex.Unlock()
+		return nil
+	}
+	n := m.append(rec)
+	m.mutex.Unlock()
+	return m.commit(n)
+}
+
+// Close flushes the log to disk and closes it. The map can still be read
+func (m *Map[K, V]) Close() error {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return ErrClosed
+	}
+	m.closed = true
+	m.mutex.Unlock()
+
+	close(m.done)
+	m.wg.Wait()
+
+	m.syncs.Lock()
+	defer m.syncs.Unlock()
+	err := m.flush(true)
+	if cerr := m.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writable returns the error a write should fail with, mutex must be held
+func (m *Map[K, V]) writable() error {
+	if m.closed {
+		return ErrClosed
+	}
+	return m.err
+}
+
+// append adds the record to the buffer, and returns its number. mutex must be
+// held
+func (m *Map[K, V]) append(rec []byte) uint64 {
+	m.buf = append(m.buf, rec...)
+	m.appends++
+	return m.appends
+}
+
+// commit makes sure the n-th record is written to the log, and flushed to disk
+// with SyncAlways. A go-routine writes the records of all the others waiting
+// meanwhile, so they are flushed with a single fsync
+func (m *Map[K, V]) commit(n uint64) error {
+	m.syncs.Lock()
+	if m.written < n {
+		m.flush(m.mode == SyncAlways)
+	}
+	compact := m.compactSize > 0 && m.size > m.compactSize
+	m.syncs.Unlock()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if compact && !m.closed && atomic.CompareAndSwapUint32(&m.running, 0, 1) {
+		// Close waits for it, as it is added before closed is set
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			defer atomic.StoreUint32(&m.running, 0)
+			m.Compact()
+		}()
+	}
+	return m.err
+}
+
+// flush writes the buffer to the log, and fsyncs it if asked to. An error is
+// kept in m.err. syncs must be held
+func (m *Map[K, V]) flush(fsync bool) error {
+	m.mutex.Lock()
+	buf, n := m.buf, m.appends
+	m.buf = nil
+	m.mutex.Unlock()
+
+	var err error
+	if len(buf) > 0 {
+		_, err = m.log.Write(buf)
+	}
+	if err == nil && fsync {
+		err = m.log.Sync()
+	}
+	if err != nil {
+		m.mutex.Lock()
+		if m.err == nil {
+			m.err = err
+		}
+		m.mutex.Unlock()
+		return err
+	}
+	m.written = n
+	m.size += int64(len(buf))
+	return nil
+}
+
+// background flushes the log and takes snapshots periodically
+func (m *Map[K, V]) background() {
+	defer m.wg.Done()
+
+	var syncC, snapC <-chan time.Time
+	if m.mode == SyncInterval {
+		t := time.NewTicker(m.syncInterval)
+		defer t.Stop()
+		syncC = t.C
+	}
+	if m.snapshotInterval > 0 {
+		t := time.NewTicker(m.snapshotInterval)
+		defer t.Stop()
+		snapC = t.C
+	}
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-syncC:
+			m.syncs.Lock()
+			m.flush(true)
+			m.syncs.Unlock()
+		case <-snapC:
+			m.syncs.Lock()
+			dirty := m.size > 0
+			m.syncs.Unlock()
+			if dirty {
+				m.Compact()
+			}
+		}
+	}
+}
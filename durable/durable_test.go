@@ -0,0 +1,187 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package durable
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// contents returns all entries of the map
+func contents[K comparable, V any](m *Map[K, V]) map[K]V {
+	kv := make(map[K]V)
+	for k, v := range m.All() {
+		kv[k] = v
+	}
+	return kv
+}
+
+func TestDurable(t *testing.T) {
+	req := require.New(t)
+
+	for _, mode := range []SyncMode{SyncNone, SyncInterval, SyncAlways} {
+		dir := t.TempDir()
+		m, err := Open[string, int](dir, SyncModeOption(mode), SyncIntervalOption(time.Millisecond))
+		req.NoError(err)
+		for i := 0; i < 100; i++ {
+			req.NoError(m.Set(string(rune('a'+i)), i))
+		}
+		for i := 0; i < 100; i += 3 {
+			req.NoError(m.Del(string(rune('a' + i))))
+		}
+		req.NoError(m.Del("absent"))
+		req.NoError(m.Set("b", 100))
+		want := contents(m)
+		req.Len(want, 66)
+		req.NoError(m.Close())
+
+		// reads still work, writes don't
+		v, ok := m.Get("b")
+		req.True(ok)
+		req.Equal(100, v)
+		req.Equal(ErrClosed, m.Set("a", 1))
+		req.Equal(ErrClosed, m.Del("b"))
+		req.Equal(ErrClosed, m.Compact())
+		req.Equal(ErrClosed, m.Close())
+
+		m, err = Open[string, int](dir, SyncModeOption(mode))
+		req.NoError(err)
+		req.Equal(want, contents(m))
+		req.NoError(m.Close())
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	req := require.New(t)
+
+	dir := t.TempDir()
+	m, err := Open[int, string](dir)
+	req.NoError(err)
+	for i := 0; i < 10; i++ {
+		req.NoError(m.Set(i, "v"))
+	}
+	req.NoError(m.Close())
+
+	// cut the last record short
+	path := m.logPath(m.seq)
+	info, err := os.Stat(path)
+	req.NoError(err)
+	req.NoError(os.Truncate(path, info.Size()-3))
+
+	m, err = Open[int, string](dir)
+	req.NoError(err)
+	req.Equal(9, m.Len())
+	_, ok := m.Get(9)
+	req.False(ok)
+	req.NoError(m.Set(9, "again"))
+	req.NoError(m.Close())
+
+	m, err = Open[int, string](dir)
+	req.NoError(err)
+	req.Equal(10, m.Len())
+	v, _ := m.Get(9)
+	req.Equal("again", v)
+	req.NoError(m.Close())
+
+	// a corrupt record before the last log fails the open
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	req.NoError(err)
+	_, err = f.WriteAt([]byte{0xff}, 10)
+	req.NoError(err)
+	req.NoError(f.Close())
+	_, err = Open[int, string](dir)
+	req.Error(err)
+}
+
+func TestCompact(t *testing.T) {
+	req := require.New(t)
+
+	dir := t.TempDir()
+	m, err := Open[int, int](dir, CompactSizeOption(1<<10))
+	req.NoError(err)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	for n := 0; n < 4; n++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Set(i%300, n*1000+i)
+				if i%7 == 0 {
+					m.Del((i + 150) % 300)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		req.NoError(m.Compact())
+	}
+	wg.Wait()
+	want := contents(m)
+	req.NoError(m.Close())
+
+	_, err = os.Stat(filepath.Join(dir, snapshotName))
+	req.NoError(err)
+	seqs, err := m.logs()
+	req.NoError(err)
+	req.Less(len(seqs), 5)
+
+	m, err = Open[int, int](dir)
+	req.NoError(err)
+	req.Equal(want, contents(m))
+
+	// periodic snapshot
+	req.NoError(m.Close())
+	m, err = Open[int, int](dir, SnapshotIntervalOption(time.Millisecond))
+	req.NoError(err)
+	req.NoError(m.Set(1000, 1))
+	seq := m.seq
+	req.Eventually(func() bool {
+		seqs, err := m.logs()
+		return err == nil && len(seqs) == 1 && seqs[0] > seq
+	}, time.Second, time.Millisecond)
+	req.NoError(m.Close())
+	m, err = Open[int, int](dir)
+	req.NoError(err)
+	v, ok := m.Get(1000)
+	req.True(ok)
+	req.Equal(1, v)
+	req.NoError(m.Close())
+}
+
+func TestInterfaceKeys(t *testing.T) {
+	req := require.New(t)
+
+	dir := t.TempDir()
+	m, err := Open[interface{}, interface{}](dir, SyncModeOption(SyncAlways))
+	req.NoError(err)
+	req.NoError(m.Set(1, "one"))
+	req.NoError(m.Set("two", []byte("2")))
+	req.NoError(m.Set(3.0, nil))
+	req.Error(m.Set(4, func() {}))
+	req.NoError(m.Compact())
+	req.NoError(m.Del(1))
+	req.NoError(m.Close())
+
+	m, err = Open[interface{}, interface{}](dir)
+	req.NoError(err)
+	req.Equal(map[interface{}]interface{}{"two": []byte("2"), 3.0: nil}, contents(m))
+	req.NoError(m.Close())
+}
@@ -0,0 +1,334 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package durable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dustinxie/lockfree/hashmap"
+)
+
+// The directory holds the latest snapshot, and the logs numbered in sequence.
+// A log is a series of records, each is
+//
+//	uint32 length of payload, uint32 CRC-32C of payload, payload
+//
+// where payload is the op, the key, and the value of a set, encoded by
+// hashmap.AppendValue. The snapshot is
+//
+//	magic "LFDS", version byte, uint64 number of the first log after it,
+//	uint32 CRC-32C of the map, the map encoded by MarshalBinary
+
+const (
+	opSet = 1
+	opDel = 2
+
+	recordHeader = 8
+
+	snapshotName    = "snapshot"
+	snapshotMagic   = "LFDS"
+	snapshotVersion = 1
+	snapshotHeader  = len(snapshotMagic) + 1 + 8 + 4
+
+	logExt = ".wal"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func appendRecord(b []byte, op byte, key, value interface{}) ([]byte, error) {
+	start := len(b)
+	b = append(b, make([]byte, recordHeader)...)
+	b = append(b, op)
+	b, err := hashmap.AppendValue(b, key)
+	if err == nil && op == opSet {
+		b, err = hashmap.AppendValue(b, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload := b[start+recordHeader:]
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[start+4:], crc32.Checksum(payload, castagnoli))
+	return b, nil
+}
+
+// replay applies the records of a log to the map, and returns the length of
+// the valid records. The rest is a record torn by a crash, or corrupted
+func (m *Map[K, V]) replay(data []byte) (int, error) {
+	var valid int
+	for len(data) >= recordHeader {
+		size := binary.LittleEndian.Uint32(data)
+		if uint64(size) > uint64(len(data)-recordHeader) {
+			break
+		}
+		payload := data[recordHeader : recordHeader+int(size)]
+		if size == 0 || crc32.Checksum(payload, castagnoli) != binary.LittleEndian.Uint32(data[4:]) {
+			break
+		}
+		if err := m.apply(payload); err != nil {
+			return valid, err
+		}
+		valid += recordHeader + int(size)
+		data = data[recordHeader+int(size):]
+	}
+	return valid, nil
+}
+
+func (m *Map[K, V]) apply(payload []byte) error {
+	op := payload[0]
+	key, rest, err := hashmap.ReadValue[K](payload[1:])
+	if err != nil {
+		return err
+	}
+	switch op {
+	case opSet:
+		value, rest, err := hashmap.ReadValue[V](rest)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return errors.New("durable: corrupt record")
+		}
+		m.m.Set(key, value)
+	case opDel:
+		if len(rest) != 0 {
+			return errors.New("durable: corrupt record")
+		}
+		m.m.Del(key)
+	default:
+		return fmt.Errorf("durable: unknown op %d", op)
+	}
+	return nil
+}
+
+// recover loads the snapshot and replays the logs after it, then starts a new
+// log
+func (m *Map[K, V]) recover() error {
+	from, err := m.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	seqs, err := m.logs()
+	if err != nil {
+		return err
+	}
+	for i, seq := range seqs {
+		path := m.logPath(seq)
+		if seq < from {
+			// left by a crash right after the snapshot was taken
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		valid, err := m.replay(data)
+		if err != nil {
+			return fmt.Errorf("durable: replaying %s: %w", path, err)
+		}
+		if valid < len(data) {
+			if i != len(seqs)-1 {
+				return fmt.Errorf("durable: corrupt log %s at offset %d", path, valid)
+			}
+			// the last write was torn by a crash
+			if err := os.Truncate(path, int64(valid)); err != nil {
+				return err
+			}
+		}
+		m.seq = seq
+		m.size += int64(valid)
+	}
+	m.seq = max(m.seq+1, from)
+	return m.openLog()
+}
+
+// openLog creates the log of the current sequence number
+func (m *Map[K, V]) openLog() error {
+	f, err := os.OpenFile(m.logPath(m.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(m.dir); err != nil {
+		f.Close()
+		return err
+	}
+	m.log = f
+	return nil
+}
+
+// logs returns the sequence numbers of the logs in the directory, in order
+func (m *Map[K, V]) logs() ([]uint64, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), logExt)
+		if !ok {
+			continue
+		}
+		if seq, err := strconv.ParseUint(name, 16, 64); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	return seqs, nil
+}
+
+func (m *Map[K, V]) logPath(seq uint64) string {
+	return filepath.Join(m.dir, fmt.Sprintf("%016x%s", seq, logExt))
+}
+
+// loadSnapshot loads the snapshot if any, and returns the number of the first
+// log after it
+func (m *Map[K, V]) loadSnapshot() (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(m.dir, snapshotName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < snapshotHeader || string(data[:len(snapshotMagic)]) != snapshotMagic || data[len(snapshotMagic)] != snapshotVersion {
+		return 0, errors.New("durable: corrupt snapshot")
+	}
+	var (
+		seq = binary.LittleEndian.Uint64(data[len(snapshotMagic)+1:])
+		sum = binary.LittleEndian.Uint32(data[len(snapshotMagic)+9:])
+	)
+	data = data[snapshotHeader:]
+	if crc32.Checksum(data, castagnoli) != sum {
+		return 0, errors.New("durable: corrupt snapshot")
+	}
+	if err := m.m.UnmarshalBinary(data); err != nil {
+		return 0, fmt.Errorf("durable: loading snapshot: %w", err)
+	}
+	return seq, nil
+}
+
+// Compact takes a snapshot of the map, and removes the logs before it. It is
+// called in the background once the logs grow beyond CompactSizeOption, or
+// every SnapshotIntervalOption
+func (m *Map[K, V]) Compact() error {
+	m.compact.Lock()
+	defer m.compact.Unlock()
+
+	// switch to a new log. The snapshot holds all records of the old logs, and
+	// the first records of the new log, replaying them again is harmless
+	m.syncs.Lock()
+	seq, err := m.rotate()
+	m.syncs.Unlock()
+	if err != nil {
+		return err
+	}
+
+	data, err := m.m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 0, snapshotHeader)
+	header = append(header, snapshotMagic...)
+	header = append(header, snapshotVersion)
+	header = binary.LittleEndian.AppendUint64(header, seq)
+	header = binary.LittleEndian.AppendUint32(header, crc32.Checksum(data, castagnoli))
+	if err := writeFile(m.dir, snapshotName, header, data); err != nil {
+		return err
+	}
+
+	seqs, err := m.logs()
+	if err != nil {
+		return err
+	}
+	for _, s := range seqs {
+		if s < seq {
+			if err := os.Remove(m.logPath(s)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rotate flushes the current log and starts a new one, and returns its number.
+// syncs must be held
+func (m *Map[K, V]) rotate() (uint64, error) {
+	m.mutex.Lock()
+	closed := m.closed
+	m.mutex.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+	if err := m.flush(true); err != nil {
+		return 0, err
+	}
+	old := m.log
+	m.seq++
+	if err := m.openLog(); err != nil {
+		m.seq--
+		return 0, err
+	}
+	m.size = 0
+	return m.seq, old.Close()
+}
+
+// writeFile replaces the file atomically with the concatenated data
+func writeFile(dir, name string, data ...[]byte) error {
+	tmp := filepath.Join(dir, name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for _, b := range data {
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes the entries of the directory to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sort"
+	"unsafe"
+)
+
+// cursor resumes the searches of a batch, whose keys are sorted by hash, so
+// each bucket is walked once. It keeps the directory the batch starts with, as
+// the position is a node of its list
+type cursor struct {
+	d   *directory
+	i   uint64    // bucket of pos
+	pos *hashNode // where the search for the next key starts, nil for the fence
+}
+
+// seek returns the position to search for the hash from, which starts over at
+// the fence once the batch moves on to another bucket
+func (c *cursor) seek(hash uint64) **hashNode {
+	if i := hash >> (64 - c.d.B); i != c.i {
+		c.i, c.pos = i, nil
+	}
+	return &c.pos
+}
+
+// byHash returns the hashes of the keys, and the indexes of the keys in hash
+// order. Repeated keys keep their order in the batch
+func (h *Map[K, V]) byHash(keys []K) ([]uint64, []int) {
+	hashes := make([]uint64, len(keys))
+	order := make([]int, len(keys))
+	for i := range keys {
+		hashes[i] = h.hash(keys[i])
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return hashes[order[a]] < hashes[order[b]]
+	})
+	return hashes, order
+}
+
+// GetMany returns the values for the keys, and whether each key is present.
+// The keys are looked up in hash order, so the keys of a bucket are found with
+// a single walk of the bucket
+func (h *Map[K, V]) GetMany(keys []K) ([]V, []bool) {
+	var (
+		values = make([]V, len(keys))
+		found  = make([]bool, len(keys))
+		c      = cursor{d: h.directory()}
+	)
+	h.gate()
+	hashes, order := h.byHash(keys)
+	for _, i := range order {
+		h.tally(&h.gets)
+		hash := hashes[i]
+		node := h.bucketAt(c.d, hash>>(64-c.d.B)).get(keys[i], hash, c.seek(hash))
+		if node == nil {
+			continue
+		}
+		if e := (*entry[V])(node.value()); e != nil {
+			if !e.expired() {
+				h.touch(node)
+				values[i], found[i] = e.val, true
+			} else {
+				h.purge(node, e)
+			}
+		}
+	}
+	return values, found
+}
+
+// SetMany sets the values for the keys, values[i] for keys[i], and returns
+// whether each key is newly inserted rather than updated. A key repeated in
+// keys is set to its last value. It panics if the lengths of keys and values
+// differ.
+//
+// The keys are stored in hash order like GetMany, and the map is expanded once
+// all keys are stored, instead of being checked on every insert
+func (h *Map[K, V]) SetMany(keys []K, values []V) []bool {
+	if len(keys) != len(values) {
+		panic("hashmap: SetMany with different numbers of keys and values")
+	}
+	return h.storeMany(keys, func(i int) *entry[V] {
+		return h.newEntry(values[i], h.ttl)
+	})
+}
+
+// storeMany stores the entry of each key returned by entry, see SetMany
+func (h *Map[K, V]) storeMany(keys []K, entry func(i int) *entry[V]) []bool {
+	var (
+		inserted = make([]bool, len(keys))
+		c        = cursor{d: h.directory()}
+	)
+	hashes, order := h.byHash(keys)
+	for _, i := range order {
+		h.tally(&h.sets)
+		// each node keeps its own copy of the key, not the whole batch
+		key := cloneKey(keys[i])
+		node := hashNode{
+			hash: hashes[i],
+			key:  unsafe.Pointer(&key),
+			val:  unsafe.Pointer(entry(i)),
+			meta: h.score(),
+		}
+		curr, old, _ := h.storeNode(&node, nil, &c)
+		h.touch(curr)
+		h.replaced(curr, old)
+		// an expired entry is absent, the same as for LoadOrStore
+		inserted[i] = old == nil || old.expired()
+	}
+	for h.isOverflow() && h.expand() {
+	}
+	return inserted
+}
+
+// DelMany deletes the keys, and returns whether each key was present. The keys
+// are deleted in hash order like GetMany, and the map is shrunk once all keys
+// are deleted
+func (h *Map[K, V]) DelMany(keys []K) []bool {
+	var (
+		deleted = make([]bool, len(keys))
+		c       = cursor{d: h.directory()}
+	)
+	hashes, order := h.byHash(keys)
+	for _, i := range order {
+		h.tally(&h.dels)
+		key := keys[i]
+		node := hashNode{
+			hash: hashes[i],
+			key:  unsafe.Pointer(&key),
+		}
+		if e, ok := h.removeNode(&node, nil, &c); ok {
+			_, deleted[i] = h.dropped(&key, e)
+		}
+	}
+	for h.isUnderflow() && h.shrink() {
+	}
+	return deleted
+}
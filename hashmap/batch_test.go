@@ -0,0 +1,161 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// colliding keeps the top 4 bits of the hash, so keys collide a lot
+type colliding struct{}
+
+func (colliding) Hash(k0, k1 uint64, p []byte) uint64 {
+	return SipHasher.Hash(k0, k1, p) & (0xf << 60)
+}
+
+func TestBatch(t *testing.T) {
+	req := require.New(t)
+
+	for _, opts := range [][]Option{
+		{BucketSizeOption(8)},
+		{HasherOption(colliding{})},
+	} {
+		m := NewMap[string, int](opts...)
+		m.Set("0", -1)
+
+		keys := make([]string, 1000)
+		values := make([]int, 1000)
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+			values[i] = i
+		}
+		// a key repeated in the batch is updated by its later value
+		keys = append(keys, "1")
+		values = append(values, 1000)
+		inserted := m.SetMany(keys, values)
+		req.Len(inserted, len(keys))
+		for i := range inserted {
+			req.Equal(i != 0 && i != 1000, inserted[i])
+		}
+		req.Equal(1000, m.Len())
+		// expanded once the batch is stored
+		req.False(m.isOverflow())
+
+		got, found := m.GetMany(append(keys[:1000:1000], "1000", "1"))
+		for i := 0; i < 1000; i++ {
+			req.True(found[i])
+			if i == 1 {
+				req.Equal(1000, got[i])
+			} else {
+				req.Equal(i, got[i])
+			}
+		}
+		req.False(found[1000])
+		req.Zero(got[1000])
+		req.True(found[1001])
+
+		// delete the odd keys, and some keys twice or absent
+		var dels []string
+		for i := 1; i < 1000; i += 2 {
+			dels = append(dels, keys[i])
+		}
+		dels = append(dels, "1", "1000")
+		deleted := m.DelMany(dels)
+		for i := range deleted {
+			req.Equal(i < 500, deleted[i])
+		}
+		req.Equal(500, m.Len())
+		for i := 0; i < 1000; i++ {
+			v, ok := m.Get(keys[i])
+			req.Equal(i%2 == 0, ok)
+			if ok {
+				req.Equal(i, v)
+			}
+		}
+		req.Empty(m.DelMany(nil))
+		req.Empty(m.SetMany(nil, nil))
+	}
+
+	// shrunk once the batch is deleted
+	m := NewMap[int, int](BucketSizeOption(8))
+	keys := make([]int, 10000)
+	for i := range keys {
+		keys[i] = i
+	}
+	m.SetMany(keys, keys)
+	req.True(m.directory().B >= 10)
+	m.DelMany(keys[100:])
+	req.Equal(100, m.Len())
+	req.False(m.isUnderflow())
+	req.True(m.directory().B <= 5)
+
+	req.Panics(func() {
+		m.SetMany(keys, nil)
+	})
+
+	// an expired key is inserted, not updated
+	m = NewMap[int, int](ExpireIntervalOption(time.Hour))
+	m.SetWithTTL(1, 1, 10*time.Millisecond)
+	m.SetWithTTL(2, 2, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	req.Equal([]bool{true, false, true}, m.SetMany([]int{1, 2, 3}, []int{11, 22, 33}))
+	req.Equal(3, m.Len())
+}
+
+func TestBatchConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func(n int) {
+			defer wg.Done()
+			// each go-routine owns the keys i%8 == n
+			for round := 0; round < 10; round++ {
+				keys := make([]int, 0, 1000)
+				for i := n; i < 8000; i += 8 {
+					keys = append(keys, i)
+				}
+				for i, ok := range m.SetMany(keys, keys) {
+					if !ok {
+						t.Errorf("key %d is not inserted", keys[i])
+					}
+				}
+				values, found := m.GetMany(keys)
+				for i := range keys {
+					if !found[i] || values[i] != keys[i] {
+						t.Errorf("key %d = %d, %v", keys[i], values[i], found[i])
+					}
+				}
+				for i, ok := range m.DelMany(keys[:len(keys)/2]) {
+					if !ok {
+						t.Errorf("key %d is not deleted", keys[i])
+					}
+				}
+				for _, k := range keys[len(keys)/2:] {
+					m.Del(k)
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	req.Zero(m.Len())
+}
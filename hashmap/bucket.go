@@ -15,132 +15,246 @@
 package hashmap
 
 import (
-	"sync"
 	"sync/atomic"
 	"unsafe"
 )
 
-type bucket struct {
-	sync.RWMutex
-	count uint32
+// bucket starts with its fence node, and holds all nodes up to the fence of
+// the next bucket. Fences are never removed from the list
+type bucket[K comparable, V any] struct {
 	fence hashNode // dummy hashNode that marks beginning of a bucket
 }
 
-func newBucket(count uint32, hash uint64) *bucket {
-	return &bucket{
-		count: count,
+func newBucket[K comparable, V any](hash uint64) *bucket[K, V] {
+	return &bucket[K, V]{
 		fence: hashNode{hash: hash},
 	}
 }
 
-func (b *bucket) size() uint32 {
-	return atomic.LoadUint32(&b.count)
+// get returns the node of the key, or nil if the key is absent. It only reads
+// the list, deleted nodes on the way are left for writers to unlink. The walk
+// starts at *from like search
+func (b *bucket[K, V]) get(key K, hash uint64, from **hashNode) *hashNode {
+	if from == nil {
+		node, _ := b.find(&b.fence, key, hash)
+		return node
+	}
+	node, pos := b.find(b.start(from), key, hash)
+	*from = pos
+	return node
 }
 
-func (b *bucket) get(key interface{}, hash uint64) (interface{}, bool) {
-	b.RLock()
-	defer b.RUnlock()
-	// running into the next fence hashNode means we exhausted all nodes in this bucket
-	for curr := b.fence.next(); !isFence(curr); curr = curr.next() {
-		if hash == curr.hash && key == *(*interface{})(curr.key) {
-			return *(*interface{})(curr.value()), true
+// find walks from start to the node of the key, and returns it along with the
+// last node passed that the walk for a larger hash can start at
+func (b *bucket[K, V]) find(start *hashNode, key K, hash uint64) (*hashNode, *hashNode) {
+	pos := start
+	for curr := start.next(); ; curr = curr.next() {
+		if isFence(curr) {
+			if hash < curr.hash || isTail(curr) {
+				return nil, pos
+			}
+			pos = curr
+			continue
+		}
+		if hash < curr.hash {
+			return nil, pos
+		}
+		if hash == curr.hash && !isMarker(curr) && keyEqual(&key, (*K)(curr.key)) {
+			return curr, pos
+		}
+		if hash > curr.hash && !isMarker(curr) {
+			pos = curr
 		}
 	}
-	return nil, false
 }
 
-// last return the last node in the bucket
-func (b *bucket) last() *hashNode {
-	curr := &b.fence
-	for next := curr.next(); !isFence(next); {
-		curr = next
-		next = next.next()
+// lookup returns the entry of the key, or nil if the key is absent
+func (b *bucket[K, V]) lookup(node *hashNode) *entry[V] {
+	if _, next, insert := b.search(node, nil); !insert {
+		return (*entry[V])(next.value())
 	}
-	return curr
+	return nil
 }
 
-func (b *bucket) upsert(node *hashNode) bool {
-	b.RLock()
-	defer b.RUnlock()
+// upsert inserts the node, or updates the value of the existing node, and
+// returns true if the node is inserted
+func (b *bucket[K, V]) upsert(node *hashNode) bool {
+	curr, _, _ := b.store(node, nil, nil, nil)
+	return curr == node
+}
+
+// store inserts the node, or updates the entry of the existing node to
+// node.val, if cond is nil or returns true on the current entry (nil if the key
+// is absent). It returns the node of the key (nil if the key is absent), its
+// entry before the update, and whether node.val is stored. Failed CAS are
+// counted into retries if it is not nil. The search starts at *from
+func (b *bucket[K, V]) store(node *hashNode, cond func(*entry[V]) bool, retries *uint64, from **hashNode) (*hashNode, *entry[V], bool) {
 	for {
-		curr, next, insert := b.search(node)
+		curr, next, insert := b.search(node, from)
 		if insert {
-			node.linkTo(next)
-			// insert the new hashNode, curr --> node --> next
-			if curr.casNext(node.nxt, unsafe.Pointer(node)) {
-				atomic.AddUint32(&b.count, 1)
-				return true
+			if cond != nil && !cond(nil) {
+				return nil, nil, false
+			}
+			if b.insert(curr, next, node) {
+				return node, nil, true
 			}
+		} else if val := next.value(); val == nil {
+			// next is being deleted, help unlink it and insert anew
+			next.mark()
 		} else {
-			val := next.value()
+			if cond != nil && !cond((*entry[V])(val)) {
+				return next, (*entry[V])(val), false
+			}
 			// update the new value
 			if next.casValue(val, node.val) {
-				return false
+				return next, (*entry[V])(val), true
 			}
 		}
+		if retries != nil {
+			atomic.AddUint64(retries, 1)
+		}
 	}
 }
 
-func (b *bucket) del(node *hashNode) bool {
-	b.Lock()
-	defer b.Unlock()
-	curr, next, insert := b.search(node)
-	if insert {
-		return false
+// insert links the new hashNode, curr --> node --> next
+func (b *bucket[K, V]) insert(curr, next, node *hashNode) bool {
+	node.linkTo(next)
+	return curr.casNext(unsafe.Pointer(next), unsafe.Pointer(node))
+}
+
+// split inserts the fence of a new bucket at the given hash, which is larger
+// than the hash of b, or returns the bucket if its fence is already there
+func (b *bucket[K, V]) split(hash uint64) *bucket[K, V] {
+	b1 := newBucket[K, V](hash)
+	for {
+		curr, next, insert := b.search(&b1.fence, nil)
+		if !insert {
+			// next is the fence, which is the 1st field of its bucket
+			return (*bucket[K, V])(unsafe.Pointer(next))
+		}
+		if b.insert(curr, next, &b1.fence) {
+			return b1
+		}
 	}
-	curr.nxt = nil
-	curr.nxt = next.nxt
-	atomic.AddUint32(&b.count, ^uint32(0))
-	return true
 }
 
-// search finds the position to insert or update the key
-func (b *bucket) search(node *hashNode) (*hashNode, *hashNode, bool) {
-	var (
-		hash          = node.hash
-		curr, next, _ = b.pivot(hash)
-	)
-	for ; hash == next.hash && !isFence(next); curr, next = next, next.next() {
-		if *(*interface{})(node.key) == *(*interface{})(next.key) {
-			// next is the node to be updated or deleted
-			return curr, next, false
+func (b *bucket[K, V]) del(node *hashNode) bool {
+	_, deleted := b.remove(node, nil, nil)
+	return deleted
+}
+
+// remove deletes the existing node if cond is nil or returns true on its entry,
+// and returns the entry and whether the node is deleted. The search starts at
+// *from.
+//
+// The node is deleted in 3 steps, each done by CAS so nothing is locked:
+//  1. its value is set to nil, the key is absent from now on
+//  2. it is marked, so no node can be inserted after it
+//  3. it is unlinked from the list, by this or any traversing go-routine
+func (b *bucket[K, V]) remove(node *hashNode, cond func(*entry[V]) bool, from **hashNode) (*entry[V], bool) {
+	for {
+		curr, next, insert := b.search(node, from)
+		if insert {
+			return nil, false
+		}
+		val := next.value()
+		if val == nil {
+			// deleted by another go-routine
+			return nil, false
+		}
+		e := (*entry[V])(val)
+		if cond != nil && !cond(e) {
+			return e, false
 		}
+		if !next.casValue(val, nil) {
+			continue
+		}
+		next.mark()
+		if !curr.casNext(unsafe.Pointer(next), unsafe.Pointer(next.next().next())) {
+			// curr has changed, let search unlink next
+			b.search(node, from)
+		}
+		return e, true
 	}
-	return curr, next, true
 }
 
-// pivot returns the node with hash < input, and number of such nodes
-func (b *bucket) pivot(hash uint64) (*hashNode, *hashNode, uint32) {
-	var (
-		curr  = &b.fence
-		next  = curr.next()
-		count uint32
-	)
-	for ; hash > next.hash; count++ {
-		curr = next
-		next = next.next()
+// search finds the position to insert or update the node: curr links to next,
+// and next is the node of the key unless insert is true. Marked nodes passed
+// on the way are unlinked.
+//
+// A fence precedes all nodes with hash >= its own, so a node is located after
+// all fences with hash <= its own, including those left by an earlier shrink.
+// If node is a fence, next is the fence of the same hash unless insert is true.
+//
+// If from is not nil, the search starts at *from, or the fence if it is nil,
+// and *from advances to curr if the search for a larger hash can start there.
+// So a batch of keys sorted by hash is searched with a single walk of the bucket
+func (b *bucket[K, V]) search(node *hashNode, from **hashNode) (*hashNode, *hashNode, bool) {
+	if from == nil {
+		return b.searchFrom(&b.fence, node)
 	}
-	return curr, next, count
+	curr, next, insert := b.searchFrom(b.start(from), node)
+	if isFence(curr) || curr.hash < node.hash {
+		// a node of the same hash may have an equal hash key before it
+		*from = curr
+	}
+	return curr, next, insert
 }
 
-// split breaks the bucket at the given hash, and returns the new bucket
-func (b *bucket) split(hash uint64) *bucket {
-	b.Lock()
-	curr, next, count := b.pivot(hash)
-	b1 := newBucket(b.count-count, hash)
-	b1.fence.linkTo(next)
-	b.count = count
-	curr.linkTo(&b1.fence)
-	b.Unlock()
-	return b1
+// start returns the node to resume a search at, the fence if there is none
+func (b *bucket[K, V]) start(from **hashNode) *hashNode {
+	if from == nil || *from == nil || isMarker((*from).next()) {
+		// a deleted node no longer leads to all nodes after it
+		return &b.fence
+	}
+	return *from
 }
 
-// merge merges 2 buckets into 1
-func (b *bucket) merge(b1 *bucket) {
-	b.Lock()
-	b1.Lock()
-	b.count += b1.count
-	b.last().linkTo(b1.fence.next())
-	b1.Unlock()
-	b.Unlock()
+func (b *bucket[K, V]) searchFrom(start, node *hashNode) (*hashNode, *hashNode, bool) {
+	var (
+		hash  = node.hash
+		fence = isFence(node)
+	)
+retry:
+	for {
+		curr, next := start, start.next()
+		if isMarker(next) {
+			// start is deleted since, begin with the fence
+			start = &b.fence
+			continue
+		}
+		for {
+			if isFence(next) {
+				// a fence is never deleted
+				if hash < next.hash || isTail(next) {
+					return curr, next, true
+				}
+				if hash == next.hash && fence {
+					return curr, next, false
+				}
+				curr, next = next, next.next()
+				continue
+			}
+			// inserting before a deleted node is fine, as it is unlinked by
+			// whoever passes it later
+			if hash < next.hash || hash == next.hash && fence {
+				return curr, next, true
+			}
+			succ := next.next()
+			if isMarker(succ) {
+				// next is deleted, unlink it
+				if !curr.casNext(unsafe.Pointer(next), unsafe.Pointer(succ.next())) {
+					// curr is deleted or has a new node after it
+					continue retry
+				}
+				next = succ.next()
+				continue
+			}
+			if hash == next.hash && keyEqual((*K)(node.key), (*K)(next.key)) {
+				// next is the node to be updated or deleted
+				return curr, next, false
+			}
+			curr, next = next, succ
+		}
+	}
 }
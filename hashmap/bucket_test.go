@@ -25,10 +25,10 @@ import (
 func TestBucket(t *testing.T) {
 	req := require.New(t)
 
-	b := newBucket(0, 0)
+	b := newBucket[interface{}, interface{}](0)
 	req.Nil(b.fence.next())
 	b.fence.linkTo(newFence())
-	req.Equal(&b.fence, b.last())
+	req.True(isTail(b.fence.next()))
 
 	tests := []struct {
 		hash uint64
@@ -47,17 +47,19 @@ func TestBucket(t *testing.T) {
 		req.True(b.upsert(&hashNode{
 			hash: tests[i].hash,
 			key:  unsafe.Pointer(&tests[i].k),
-			val:  unsafe.Pointer(&tests[i].v),
+			val:  unsafe.Pointer(&entry[interface{}]{val: tests[i].v}),
 		}))
 	}
 
-	req.EqualValues(len(tests), b.count)
-	last := b.last()
+	var last *hashNode
+	for curr := b.fence.next(); !isTail(curr); curr = curr.next() {
+		last = curr
+	}
 	req.Equal(tests[len(tests)-1].hash, last.hash)
 	req.Equal(tests[len(tests)-1].k, *(*interface{})(last.key))
-	req.Equal(tests[len(tests)-1].v, *(*interface{})(last.val))
+	req.Equal(tests[len(tests)-1].v, (*entry[interface{}])(last.val).val)
 	for i := range tests {
-		v, ok := b.get(tests[i].k, tests[i].hash)
+		v, ok := getValue(b, tests[i].k, tests[i].hash)
 		req.True(ok)
 		req.Equal(tests[i].v, v)
 	}
@@ -91,7 +93,7 @@ func TestBucket(t *testing.T) {
 	for i := range searchTests {
 		node.hash = searchTests[i].hash
 		node.key = unsafe.Pointer(&searchTests[i].k)
-		curr, next, insert := b.search(&node)
+		curr, next, insert := b.search(&node, nil)
 
 		if c := searchTests[i].curr; c == -1 {
 			req.True(isFence(curr))
@@ -109,46 +111,58 @@ func TestBucket(t *testing.T) {
 		req.Equal(searchTests[i].insert, insert)
 	}
 
+	// a search resumed in hash order ends at the same position, and so does get
+	var from, pos *hashNode
+	for i := range searchTests {
+		node.hash = searchTests[i].hash
+		node.key = unsafe.Pointer(&searchTests[i].k)
+		curr, next, insert := b.search(&node, nil)
+		curr1, next1, insert1 := b.search(&node, &from)
+		req.Equal(curr, curr1)
+		req.Equal(next, next1)
+		req.Equal(insert, insert1)
+		req.True(isFence(from) || from.hash < node.hash)
+		req.Equal(b.get(searchTests[i].k, node.hash, nil), b.get(searchTests[i].k, node.hash, &pos))
+	}
+
 	for i := range searchTests {
 		req.Equal(searchTests[i].insert, b.upsert(&hashNode{
 			hash: searchTests[i].hash,
 			key:  unsafe.Pointer(&searchTests[i].k),
-			val:  unsafe.Pointer(&searchTests[i].v),
+			val:  unsafe.Pointer(&entry[interface{}]{val: searchTests[i].v}),
 		}))
 	}
 
-	// test pivot
+	// test split
 	splitTests := []struct {
-		hash  uint64
-		curr  int
-		count uint32
+		hash uint64
+		curr int
 	}{
-		{0, -1, 0},
-		{1, 0, 1},
-		{3, 2, 3},
-		{10, 3, 4},
-		{11, 7, 8},
-		{20, 8, 9},
-		{27, 10, 11},
-		{math.MaxUint64, 11, 12},
+		{3, 3},
+		{10, 4},
+		{11, 8},
+		{27, 11},
 	}
 
-	for _, v := range splitTests {
-		curr, _, count := b.pivot(v.hash)
-		req.Equal(v.count, count)
-		if count == 0 {
-			req.True(isFence(curr))
-		} else {
-			req.Equal(searchTests[v.curr].hash, curr.hash)
-			req.Equal(searchTests[v.curr].k, *(*interface{})(curr.key))
+	fences := make([]*bucket[interface{}, interface{}], len(splitTests))
+	for i, v := range splitTests {
+		fences[i] = b.split(v.hash)
+		req.Equal(v.hash, fences[i].fence.hash)
+		req.True(isFence(&fences[i].fence))
+		req.Equal(searchTests[v.curr].k, *(*interface{})(fences[i].fence.next().key))
+		// the fence precedes all nodes with hash >= its own
+		curr, next, insert := b.search(&fences[i].fence, nil)
+		req.False(insert)
+		req.Equal(&fences[i].fence, next)
+		req.Less(curr.hash, v.hash)
+		// split again returns the same bucket
+		req.Equal(fences[i], b.split(v.hash))
+		if i > 0 {
+			req.Equal(fences[i], fences[i-1].split(v.hash))
 		}
 	}
-
-	// test split
-	pivot := splitTests[7].hash
-	b1 := b.split(pivot)
-	req.Equal(splitTests[7].count, b.count)
-	req.Equal(uint32(len(searchTests))-b.count, b1.count)
+	pivot := splitTests[1].hash
+	b1 := fences[1]
 
 	// test delete
 	node = hashNode{
@@ -157,8 +171,33 @@ func TestBucket(t *testing.T) {
 	}
 	req.False(b.del(&node))
 	node.hash = searchTests[2].hash
+	curr, next, insert := b.search(&node, nil)
+	req.False(insert)
 	req.True(b.del(&node))
-	req.Equal(splitTests[7].count-1, b.count)
+	req.False(b.del(&node))
+
+	// the deleted node is marked and unlinked, but still links to the list
+	req.True(next.value() == nil)
+	req.True(isMarker(next.next()))
+	req.Equal(curr.next(), next.next().next())
+
+	// a node marked by another go-routine is unlinked by search
+	node.hash = searchTests[3].hash
+	node.key = unsafe.Pointer(&searchTests[3].k)
+	curr, next, _ = b.search(&node, nil)
+	next.casValue(next.value(), nil)
+	next.mark()
+	node.hash = searchTests[4].hash
+	node.key = unsafe.Pointer(&searchTests[4].k)
+	_, succ, insert := b.search(&node, nil)
+	req.False(insert)
+	req.Equal(searchTests[4].k, *(*interface{})(succ.key))
+	req.Equal(&fences[1].fence, curr.next())
+	req.True(b.upsert(&hashNode{
+		hash: searchTests[3].hash,
+		key:  unsafe.Pointer(&searchTests[3].k),
+		val:  unsafe.Pointer(&entry[interface{}]{val: searchTests[3].v}),
+	}))
 
 	// final count
 	var (
@@ -166,10 +205,16 @@ func TestBucket(t *testing.T) {
 		ok bool
 	)
 	for i := range searchTests {
-		if hash := searchTests[i].hash; hash < pivot {
-			v, ok = b.get(searchTests[i].k, hash)
+		// a bucket finds the keys of the buckets split from it
+		hash := searchTests[i].hash
+		v, ok = getValue(b, searchTests[i].k, hash)
+		if hash >= pivot {
+			v1, ok1 := getValue(b1, searchTests[i].k, hash)
+			req.Equal(ok, ok1)
+			req.Equal(v, v1)
 		} else {
-			v, ok = b1.get(searchTests[i].k, hash)
+			_, ok1 := getValue(b1, searchTests[i].k, hash)
+			req.False(ok1)
 		}
 		if i != 2 {
 			req.True(ok)
@@ -180,3 +225,10 @@ func TestBucket(t *testing.T) {
 		}
 	}
 }
+
+func getValue(b *bucket[interface{}, interface{}], key interface{}, hash uint64) (interface{}, bool) {
+	if n := b.get(key, hash, nil); n != nil {
+		return (*entry[interface{}])(n.value()).val, true
+	}
+	return nil, false
+}
@@ -0,0 +1,622 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Keys and values are encoded with a tag of their type, so that the map with
+// interface{} key and value is decoded into the same types. The predeclared
+// types have builtin tags, other types are tagged with their registered name,
+// and encoded by their own marshaler, or gob if there is none.
+//
+// The binary encoding is
+//
+//	magic "LFHM", version byte, uvarint number of entries
+//	for each entry: key, value, varint expiry in unix nanoseconds (0 if none)
+//
+// where a key or value is a tag byte followed by its payload
+
+const (
+	codecMagic   = "LFHM"
+	codecVersion = 1
+
+	tagNil  = 0    // nil interface or pointer
+	tagUser = 0xff // tagged by name, followed by the encoded value
+)
+
+type (
+	// basicType is a predeclared type with a builtin tag
+	basicType struct {
+		name string
+		typ  reflect.Type
+	}
+
+	// jsonEntry is an entry in JSON encoding
+	jsonEntry struct {
+		Key    jsonValue `json:"key"`
+		Value  jsonValue `json:"value"`
+		Expire int64     `json:"expire,omitempty"`
+	}
+
+	// jsonValue is a key or value in JSON encoding
+	jsonValue struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+
+	// decoder reads the binary encoding, and keeps the first error
+	decoder struct {
+		buf []byte
+		err error
+	}
+)
+
+var (
+	// basicTypes are indexed by their tag
+	basicTypes = []basicType{
+		{"nil", nil},
+		{"bool", reflect.TypeFor[bool]()},
+		{"int", reflect.TypeFor[int]()},
+		{"int8", reflect.TypeFor[int8]()},
+		{"int16", reflect.TypeFor[int16]()},
+		{"int32", reflect.TypeFor[int32]()},
+		{"int64", reflect.TypeFor[int64]()},
+		{"uint", reflect.TypeFor[uint]()},
+		{"uint8", reflect.TypeFor[uint8]()},
+		{"uint16", reflect.TypeFor[uint16]()},
+		{"uint32", reflect.TypeFor[uint32]()},
+		{"uint64", reflect.TypeFor[uint64]()},
+		{"uintptr", reflect.TypeFor[uintptr]()},
+		{"float32", reflect.TypeFor[float32]()},
+		{"float64", reflect.TypeFor[float64]()},
+		{"complex64", reflect.TypeFor[complex64]()},
+		{"complex128", reflect.TypeFor[complex128]()},
+		{"string", reflect.TypeFor[string]()},
+		{"[]byte", reflect.TypeFor[[]byte]()},
+	}
+
+	// basicTags maps a predeclared type to its tag
+	basicTags = make(map[reflect.Type]byte)
+
+	// registry of the names of user types
+	registry = struct {
+		sync.RWMutex
+		types map[string]reflect.Type
+		names map[reflect.Type]string
+	}{
+		types: make(map[string]reflect.Type),
+		names: make(map[reflect.Type]string),
+	}
+
+	errCorrupt = errors.New("hashmap: corrupt encoding")
+)
+
+func init() {
+	for i, b := range basicTypes[1:] {
+		basicTags[b.typ] = byte(i + 1)
+	}
+}
+
+// Register records the type of value under its type name, like gob.Register.
+// A key or value of a user type can only be decoded into an interface{} if its
+// type is registered
+func Register(value interface{}) {
+	RegisterName(reflect.TypeOf(value).String(), value)
+}
+
+// RegisterName records the type of value under the name. It panics if the
+// name or the type is already registered otherwise
+func RegisterName(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	for _, b := range basicTypes {
+		if name == b.name {
+			panic(fmt.Sprintf("hashmap: registering reserved name %q", name))
+		}
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if r, ok := registry.types[name]; ok && r != t {
+		panic(fmt.Sprintf("hashmap: registering duplicate types for %q: %s != %s", name, r, t))
+	}
+	if r, ok := registry.names[t]; ok && r != name {
+		panic(fmt.Sprintf("hashmap: registering duplicate names for %s: %q != %q", t, r, name))
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+}
+
+// typeName returns the registered name of a user type, or its type name
+func typeName(t reflect.Type) string {
+	registry.RLock()
+	defer registry.RUnlock()
+	if name, ok := registry.names[t]; ok {
+		return name
+	}
+	return t.String()
+}
+
+// userType returns the type to decode a tagged user value into, which is the
+// static type unless it is an interface
+func userType(name string, static reflect.Type) (reflect.Type, error) {
+	if static.Kind() != reflect.Interface {
+		if typeName(static) != name {
+			return nil, fmt.Errorf("hashmap: cannot decode %s into %s", name, static)
+		}
+		return static, nil
+	}
+	registry.RLock()
+	t, ok := registry.types[name]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("hashmap: type %q is not registered", name)
+	}
+	if !t.Implements(static) {
+		return nil, fmt.Errorf("hashmap: type %s does not implement %s", t, static)
+	}
+	return t, nil
+}
+
+// basicFor returns the value of a builtin tag as the static type
+func basicFor(tag byte, v interface{}, static reflect.Type) (interface{}, error) {
+	if t := basicTypes[tag].typ; static.Kind() != reflect.Interface && t != static {
+		return nil, fmt.Errorf("hashmap: cannot decode %s into %s", t, static)
+	}
+	return v, nil
+}
+
+// isNil returns true if v is nil, or a nil pointer, map, slice, etc.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// MarshalBinary encodes a point-in-time view of the map, writes keep running
+// meanwhile. Expiry of entries is kept, expired entries are dropped
+func (h *Map[K, V]) MarshalBinary() ([]byte, error) {
+	items := h.snapshot()
+	b := append([]byte(codecMagic), codecVersion)
+	b = binary.AppendUvarint(b, uint64(len(items)))
+	var err error
+	for _, it := range items {
+		if b, err = appendTagged(b, it.key); err != nil {
+			return nil, err
+		}
+		if b, err = appendTagged(b, it.e.val); err != nil {
+			return nil, err
+		}
+		b = binary.AppendVarint(b, it.e.expire)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary stores the entries encoded by MarshalBinary into the map.
+// Existing keys are overwritten, the map is left intact if data is invalid
+func (h *Map[K, V]) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	if string(d.next(len(codecMagic))) != codecMagic || d.byte() != codecVersion {
+		return errCorrupt
+	}
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.buf)) {
+		// each entry takes more than a byte
+		return errCorrupt
+	}
+	var (
+		kt    = reflect.TypeFor[K]()
+		vt    = reflect.TypeFor[V]()
+		items = make([]item[K, V], 0, n)
+	)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		k, err := d.tagged(kt)
+		if err != nil {
+			return err
+		}
+		v, err := d.tagged(vt)
+		if err != nil {
+			return err
+		}
+		key, _ := k.(K)
+		val, _ := v.(V)
+		items = append(items, item[K, V]{key, &entry[V]{val: val, expire: d.varint()}})
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buf) != 0 {
+		return errCorrupt
+	}
+	h.load(items)
+	return nil
+}
+
+// GobEncode encodes the map like MarshalBinary
+func (h *Map[K, V]) GobEncode() ([]byte, error) {
+	return h.MarshalBinary()
+}
+
+// GobDecode decodes the map like UnmarshalBinary
+func (h *Map[K, V]) GobDecode(data []byte) error {
+	return h.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes a point-in-time view of the map as an array of entries,
+// each key and value is an object of its type name and JSON value
+func (h *Map[K, V]) MarshalJSON() ([]byte, error) {
+	var (
+		items   = h.snapshot()
+		entries = make([]jsonEntry, len(items))
+		err     error
+	)
+	for i, it := range items {
+		if entries[i].Key, err = toJSON(it.key); err != nil {
+			return nil, err
+		}
+		if entries[i].Value, err = toJSON(it.e.val); err != nil {
+			return nil, err
+		}
+		entries[i].Expire = it.e.expire
+	}
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON stores the entries encoded by MarshalJSON into the map.
+// Existing keys are overwritten, the map is left intact if data is invalid
+func (h *Map[K, V]) UnmarshalJSON(data []byte) error {
+	var entries []jsonEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	var (
+		kt    = reflect.TypeFor[K]()
+		vt    = reflect.TypeFor[V]()
+		items = make([]item[K, V], len(entries))
+	)
+	for i, e := range entries {
+		k, err := fromJSON(e.Key, kt)
+		if err != nil {
+			return err
+		}
+		v, err := fromJSON(e.Value, vt)
+		if err != nil {
+			return err
+		}
+		key, _ := k.(K)
+		val, _ := v.(V)
+		items[i] = item[K, V]{key, &entry[V]{val: val, expire: e.Expire}}
+	}
+	h.load(items)
+	return nil
+}
+
+// load stores the decoded entries, and drops those already expired. A zero
+// Map is initialized first, so it can be decoded into by gob or json
+func (h *Map[K, V]) load(items []item[K, V]) {
+	if atomic.LoadPointer(&h.dir) == nil {
+		h.init(nil)
+	}
+	now := time.Now().UnixNano()
+	for _, it := range items {
+		if it.e.expire != 0 {
+			if it.e.expire <= now {
+				continue
+			}
+			h.startJanitor()
+		}
+		h.swap(it.key, it.e)
+	}
+}
+
+// AppendValue appends the tagged binary encoding of a key or value, which is
+// the same as in MarshalBinary
+func AppendValue(b []byte, v interface{}) ([]byte, error) {
+	return appendTagged(b, v)
+}
+
+// ReadValue decodes a key or value encoded by AppendValue into T, and returns
+// the bytes after it
+func ReadValue[T any](data []byte) (T, []byte, error) {
+	d := decoder{buf: data}
+	v, err := d.tagged(reflect.TypeFor[T]())
+	if err != nil {
+		var t T
+		return t, nil, err
+	}
+	t, _ := v.(T)
+	return t, d.buf, nil
+}
+
+func appendTagged(b []byte, v interface{}) ([]byte, error) {
+	if isNil(v) {
+		return append(b, tagNil), nil
+	}
+	t := reflect.TypeOf(v)
+	tag, ok := basicTags[t]
+	if !ok {
+		data, err := marshalUser(v)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, tagUser)
+		b = appendString(b, typeName(t))
+		return appendString(b, string(data)), nil
+	}
+
+	b = append(b, tag)
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(b, rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(b, rv.Uint()), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(rv.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(rv.Float())), nil
+	case reflect.Complex64:
+		c := rv.Complex()
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(real(c))))
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(imag(c)))), nil
+	case reflect.Complex128:
+		c := rv.Complex()
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(real(c)))
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(imag(c))), nil
+	case reflect.String:
+		return appendString(b, rv.String()), nil
+	default: // []byte
+		return appendString(b, string(rv.Bytes())), nil
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// marshalUser encodes a value of user type by its own marshaler, or gob
+func marshalUser(v interface{}) ([]byte, error) {
+	if m, ok := v.(encoding.BinaryMarshaler); ok {
+		return m.MarshalBinary()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, fmt.Errorf("hashmap: encoding %T: %w", v, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalUser decodes a value of user type encoded by marshalUser
+func unmarshalUser(data []byte, t reflect.Type) (interface{}, error) {
+	p := reflect.New(t)
+	if u, ok := p.Interface().(encoding.BinaryUnmarshaler); ok {
+		if err := u.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+	} else if err := gob.NewDecoder(bytes.NewReader(data)).DecodeValue(p); err != nil {
+		return nil, fmt.Errorf("hashmap: decoding %s: %w", t, err)
+	}
+	return p.Elem().Interface(), nil
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = errCorrupt
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errCorrupt
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// tagged decodes a key or value into the static type
+func (d *decoder) tagged(static reflect.Type) (interface{}, error) {
+	tag := d.byte()
+	if d.err != nil {
+		return nil, d.err
+	}
+	switch {
+	case tag == tagNil:
+		return reflect.Zero(static).Interface(), nil
+	case tag == tagUser:
+		name, data := d.string(), d.string()
+		if d.err != nil {
+			return nil, d.err
+		}
+		t, err := userType(name, static)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalUser([]byte(data), t)
+	case int(tag) >= len(basicTypes):
+		return nil, errCorrupt
+	}
+
+	rv := reflect.New(basicTypes[tag].typ).Elem()
+	switch rv.Kind() {
+	case reflect.Bool:
+		rv.SetBool(d.byte() != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		rv.SetInt(d.varint())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		rv.SetUint(d.uvarint())
+	case reflect.Float32:
+		rv.SetFloat(float64(math.Float32frombits(d.uint32())))
+	case reflect.Float64:
+		rv.SetFloat(math.Float64frombits(d.uint64()))
+	case reflect.Complex64:
+		re, im := math.Float32frombits(d.uint32()), math.Float32frombits(d.uint32())
+		rv.SetComplex(complex(float64(re), float64(im)))
+	case reflect.Complex128:
+		re, im := math.Float64frombits(d.uint64()), math.Float64frombits(d.uint64())
+		rv.SetComplex(complex(re, im))
+	case reflect.String:
+		rv.SetString(d.string())
+	default: // []byte
+		rv.SetBytes([]byte(d.string()))
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return basicFor(tag, rv.Interface(), static)
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// toJSON encodes a key or value with its type name
+func toJSON(v interface{}) (jsonValue, error) {
+	if isNil(v) {
+		return jsonValue{Type: basicTypes[tagNil].name}, nil
+	}
+	var (
+		t    = reflect.TypeOf(v)
+		name string
+	)
+	if tag, ok := basicTags[t]; ok {
+		name = basicTypes[tag].name
+		switch c := v.(type) {
+		case complex64:
+			v = [2]float32{real(c), imag(c)}
+		case complex128:
+			v = [2]float64{real(c), imag(c)}
+		}
+	} else {
+		name = typeName(t)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return jsonValue{}, err
+	}
+	return jsonValue{Type: name, Value: data}, nil
+}
+
+// fromJSON decodes a key or value encoded by toJSON into the static type
+func fromJSON(jv jsonValue, static reflect.Type) (interface{}, error) {
+	if jv.Type == basicTypes[tagNil].name {
+		return reflect.Zero(static).Interface(), nil
+	}
+	for tag, b := range basicTypes[1:] {
+		if jv.Type != b.name {
+			continue
+		}
+		var v interface{}
+		switch b.typ.Kind() {
+		case reflect.Complex64:
+			var c [2]float32
+			if err := json.Unmarshal(jv.Value, &c); err != nil {
+				return nil, err
+			}
+			v = complex(c[0], c[1])
+		case reflect.Complex128:
+			var c [2]float64
+			if err := json.Unmarshal(jv.Value, &c); err != nil {
+				return nil, err
+			}
+			v = complex(c[0], c[1])
+		default:
+			p := reflect.New(b.typ)
+			if err := json.Unmarshal(jv.Value, p.Interface()); err != nil {
+				return nil, err
+			}
+			v = p.Elem().Interface()
+		}
+		return basicFor(byte(tag+1), v, static)
+	}
+
+	t, err := userType(jv.Type, static)
+	if err != nil {
+		return nil, err
+	}
+	p := reflect.New(t)
+	if err := json.Unmarshal(jv.Value, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	userID uint32
+
+	point struct {
+		X, Y int
+	}
+)
+
+func TestCodec(t *testing.T) {
+	req := require.New(t)
+
+	RegisterName("hashmap.userID", userID(0))
+	Register(point{})
+	req.Panics(func() { RegisterName("int", 0) })
+	req.Panics(func() { RegisterName("hashmap.userID", point{}) })
+	req.Panics(func() { RegisterName("point", point{}) })
+
+	kv := map[interface{}]interface{}{
+		true:            false,
+		1:               nil,
+		int8(-2):        int16(3),
+		int32(-4):       int64(5),
+		uint(6):         uint8(7),
+		uint16(8):       uint32(9),
+		uint64(10):      uintptr(11),
+		float32(1.5):    2.5,
+		complex64(1i):   complex128(2 + 3i),
+		"key":           []byte("value"),
+		userID(12):      point{1, 2},
+		point{3, 4}:     userID(13),
+		"":              "",
+		int64(-1 << 62): uint64(1 << 63),
+	}
+	m := New()
+	for k, v := range kv {
+		m.Set(k, v)
+	}
+	m.SetWithTTL("ttl", 1, time.Hour)
+	m.SetWithTTL("expired", 1, time.Nanosecond)
+
+	check := func(m1 *hmap) {
+		req.Equal(len(kv)+1, m1.Len())
+		for k, v := range kv {
+			v1, ok := m1.Get(k)
+			req.True(ok, k)
+			req.Equal(v, v1)
+		}
+		req.Equal(expiry(m, "ttl"), expiry(m1, "ttl"))
+		_, ok := m1.Get("expired")
+		req.False(ok)
+	}
+
+	// binary
+	data, err := m.MarshalBinary()
+	req.NoError(err)
+	m1 := New()
+	req.NoError(m1.UnmarshalBinary(data))
+	check(m1)
+
+	// gob, into a zero map
+	var buf bytes.Buffer
+	req.NoError(gob.NewEncoder(&buf).Encode(struct{ M *hmap }{m}))
+	var g struct{ M *hmap }
+	req.NoError(gob.NewDecoder(&buf).Decode(&g))
+	check(g.M)
+
+	// json, into a zero map
+	data, err = json.Marshal(m)
+	req.NoError(err)
+	var j struct{ M *hmap }
+	req.NoError(json.Unmarshal([]byte(`{"M":`+string(data)+`}`), &j))
+	check(j.M)
+
+	// existing keys are overwritten, others are kept
+	m1 = New()
+	m1.Set(1, 1)
+	m1.Set("other", 1)
+	req.NoError(json.Unmarshal(data, m1))
+	v, _ := m1.Get(1)
+	req.Nil(v)
+	req.Equal(len(kv)+2, m1.Len())
+}
+
+func TestCodecZero(t *testing.T) {
+	req := require.New(t)
+
+	// a zero map encodes as an empty one, and decodes back
+	var m Map[string, int]
+	data, err := m.MarshalBinary()
+	req.NoError(err)
+	var m1 Map[string, int]
+	req.NoError(m1.UnmarshalBinary(data))
+	req.Zero(m1.Len())
+
+	type holder struct{ M Map[string, int] }
+	var buf bytes.Buffer
+	req.NoError(gob.NewEncoder(&buf).Encode(&holder{}))
+	var g holder
+	req.NoError(gob.NewDecoder(&buf).Decode(&g))
+	req.Zero(g.M.Len())
+
+	data, err = json.Marshal(&holder{})
+	req.NoError(err)
+	req.Equal(`{"M":[]}`, string(data))
+	var j holder
+	req.NoError(json.Unmarshal(data, &j))
+	req.Zero(j.M.Len())
+	j.M.Set("a", 1)
+	data, err = json.Marshal(&j)
+	req.NoError(err)
+	var j1 holder
+	req.NoError(json.Unmarshal(data, &j1))
+	v, ok := j1.M.Get("a")
+	req.True(ok)
+	req.Equal(1, v)
+}
+
+func TestCodecTypes(t *testing.T) {
+	req := require.New(t)
+
+	// the static types are decoded into without registering
+	type node struct {
+		Name string
+		Tags []string
+	}
+	m := NewMap[[2]int, *node]()
+	m.Set([2]int{1, 2}, &node{"a", []string{"x"}})
+	m.Set([2]int{3, 4}, nil)
+	for _, codec := range []struct {
+		marshal   func() ([]byte, error)
+		unmarshal func(*Map[[2]int, *node], []byte) error
+	}{
+		{m.MarshalBinary, (*Map[[2]int, *node]).UnmarshalBinary},
+		{m.MarshalJSON, (*Map[[2]int, *node]).UnmarshalJSON},
+	} {
+		data, err := codec.marshal()
+		req.NoError(err)
+		m1 := NewMap[[2]int, *node]()
+		req.NoError(codec.unmarshal(m1, data))
+		req.Equal(2, m1.Len())
+		v, ok := m1.Get([2]int{1, 2})
+		req.True(ok)
+		req.Equal(&node{"a", []string{"x"}}, v)
+		v, ok = m1.Get([2]int{3, 4})
+		req.True(ok)
+		req.Nil(v)
+	}
+
+	// but not into interface{}, nor other types
+	data, err := m.MarshalBinary()
+	req.NoError(err)
+	err = New().UnmarshalBinary(data)
+	req.Error(err)
+	req.Contains(err.Error(), "is not registered")
+	data, err = m.MarshalJSON()
+	req.NoError(err)
+	err = NewMap[string, *node]().UnmarshalJSON(data)
+	req.Error(err)
+	req.Contains(err.Error(), "cannot decode")
+
+	// a type that cannot be encoded
+	m2 := NewMap[int, func()]()
+	m2.Set(1, func() {})
+	_, err = m2.MarshalBinary()
+	req.Error(err)
+	_, err = m2.MarshalJSON()
+	req.Error(err)
+}
+
+func TestCodecCorrupt(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[string, int]()
+	for i := 0; i < 10; i++ {
+		m.Set(string(rune('a'+i)), i)
+	}
+	data, err := m.MarshalBinary()
+	req.NoError(err)
+
+	m1 := NewMap[string, int]()
+	for i := 0; i < len(data); i++ {
+		req.Error(m1.UnmarshalBinary(data[:i]))
+	}
+	req.Error(m1.UnmarshalBinary(append(data, 0)))
+	bad := bytes.Clone(data)
+	bad[4] = 2
+	req.Error(m1.UnmarshalBinary(bad))
+	req.Zero(m1.Len())
+	req.Error(m1.UnmarshalJSON([]byte(`[{"key":{"type":"string","value":"a"},"value":{"type":"int","value":"x"}}]`)))
+	req.Zero(m1.Len())
+}
+
+// expiry returns the deadline of the key, -1 if absent
+func expiry[K comparable, V any](m *Map[K, V], key K) int64 {
+	for _, it := range m.snapshot() {
+		if it.key == key {
+			return it.e.expire
+		}
+	}
+	return -1
+}
+
+func TestAppendValue(t *testing.T) {
+	req := require.New(t)
+
+	Register(point{})
+	b, err := AppendValue(nil, "key")
+	req.NoError(err)
+	b, err = AppendValue(b, point{1, 2})
+	req.NoError(err)
+	k, rest, err := ReadValue[string](b)
+	req.NoError(err)
+	req.Equal("key", k)
+	p, rest, err := ReadValue[interface{}](rest)
+	req.NoError(err)
+	req.Equal(point{1, 2}, p)
+	req.Empty(rest)
+	_, _, err = ReadValue[int](b)
+	req.Error(err)
+	_, _, err = ReadValue[string](b[:2])
+	req.Error(err)
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// EvictionPolicy scores the entries of a bounded map. Once the map is full,
+	// entries of a randomly sampled bucket are compared, and the one with the
+	// lowest score is evicted
+	EvictionPolicy interface {
+		// Insert returns the score of a new entry
+		Insert() uint64
+
+		// Access returns the score of an entry after it is read or written
+		Access(score uint64) uint64
+	}
+
+	lru    struct{}
+	lfu    struct{}
+	fifo   struct{}
+	random struct{}
+)
+
+// built-in eviction policies
+var (
+	// LRU evicts the least recently used entry, the access time is recorded
+	// with millisecond granularity
+	LRU EvictionPolicy = lru{}
+
+	// LFU evicts the least frequently used entry
+	LFU EvictionPolicy = lfu{}
+
+	// FIFO evicts the oldest entry
+	FIFO EvictionPolicy = fifo{}
+
+	// Random evicts a random entry
+	Random EvictionPolicy = random{}
+)
+
+func (lru) Insert() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+func (lru) Access(score uint64) uint64 {
+	// only a new millisecond is written back to the node
+	return uint64(time.Now().UnixMilli())
+}
+
+func (lfu) Insert() uint64 {
+	return 1
+}
+
+func (lfu) Access(score uint64) uint64 {
+	if score == math.MaxUint64 {
+		return score
+	}
+	return score + 1
+}
+
+func (fifo) Insert() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+func (fifo) Access(score uint64) uint64 {
+	return score
+}
+
+func (random) Insert() uint64 {
+	return rand.Uint64()
+}
+
+func (random) Access(score uint64) uint64 {
+	return score
+}
+
+// MaxEntriesOption caps the number of entries in the map. Once a new key is set
+// into a full map, an entry is evicted according to the EvictionPolicyOption
+func MaxEntriesOption(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxEntries = uint64(n)
+		}
+	}
+}
+
+// EvictionPolicyOption sets the eviction policy of a bounded map, LRU is used
+// if not set
+func EvictionPolicyOption(policy EvictionPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+// OnEvictOption sets the callback which is called once for each entry evicted
+// from a full map
+func OnEvictOption(f func(key, value interface{})) Option {
+	return func(o *options) {
+		o.onEvict = f
+	}
+}
+
+// score returns the eviction score of a new node
+func (h *Map[K, V]) score() uint64 {
+	if h.policy == nil {
+		return 0
+	}
+	return h.policy.Insert()
+}
+
+// touch updates the eviction score of the node after it is read or written
+func (h *Map[K, V]) touch(node *hashNode) {
+	if h.policy == nil || node == nil {
+		return
+	}
+	// skip the write if score is unchanged, to keep the node's cache line clean
+	old := atomic.LoadUint64(&node.meta)
+	if score := h.policy.Access(old); score != old {
+		atomic.StoreUint64(&node.meta, score)
+	}
+}
+
+// evict removes entries other than the newly inserted node until the map is no
+// longer over its capacity. It gives up after a few attempts in a row fail to
+// remove an entry, which happens when concurrent writers are evicting too
+func (h *Map[K, V]) evict(node *hashNode) {
+	for fails := 0; uint64(h.Len()) > h.maxEntries && fails < 3; {
+		if !h.evictOne(node) {
+			fails++
+		}
+	}
+}
+
+// evictOne samples a random bucket and evicts its entry with the lowest score,
+// an expired entry is always picked first. If the bucket is empty, the walk
+// goes on to the next buckets, wrapping around at the end of the list, so a
+// sparse map still finds a victim
+func (h *Map[K, V]) evictOne(skip *hashNode) bool {
+	start := &h.getBucket(rand.Uint64()).fence
+
+	var (
+		victim   *hashNode
+		ve       *entry[V]
+		minScore uint64 = math.MaxUint64
+		wrapped  bool
+	)
+	for curr := start.next(); ; curr = curr.next() {
+		if isFence(curr) {
+			if victim != nil {
+				// the end of the first bucket with entries
+				break
+			}
+			if isTail(curr) {
+				if wrapped {
+					break
+				}
+				wrapped, curr = true, &h.getBucket(0).fence
+			} else if wrapped && curr.hash >= start.hash {
+				// back to where the walk started
+				break
+			}
+			continue
+		}
+		if curr == skip || isMarker(curr) {
+			continue
+		}
+		e := (*entry[V])(curr.value())
+		if e == nil {
+			continue
+		}
+		if e.expired() {
+			victim, ve = curr, e
+			break
+		}
+		if score := atomic.LoadUint64(&curr.meta); victim == nil || score < minScore {
+			victim, ve, minScore = curr, e, score
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	node := hashNode{
+		hash: victim.hash,
+		key:  victim.key,
+	}
+	_, deleted := h.removeNode(&node, func(curr *entry[V]) bool {
+		return curr == ve
+	}, nil)
+	if !deleted {
+		return false
+	}
+
+	if ve.expired() {
+		h.removed((*K)(node.key), ve)
+	} else {
+		h.deleted()
+		if h.onEvict != nil {
+			h.onEvict(*(*K)(node.key), ve.val)
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvict(t *testing.T) {
+	req := require.New(t)
+
+	tests := []struct {
+		policy EvictionPolicy
+		// checks the number of evicted hot keys [0, 50) which are accessed
+		// before evicting, and cold keys [50, 100) which are not
+		check func(hot, cold int) bool
+	}{
+		{LRU, func(hot, cold int) bool { return hot < cold }},
+		{LFU, func(hot, cold int) bool { return hot < cold }},
+		{FIFO, func(hot, cold int) bool { return hot > cold }},
+		{Random, func(hot, cold int) bool { return true }},
+	}
+
+	for _, test := range tests {
+		var (
+			mu      sync.Mutex
+			evicted = make(map[interface{}]interface{})
+		)
+		m := New(
+			BucketSizeOption(8),
+			MaxEntriesOption(100),
+			EvictionPolicyOption(test.policy),
+			OnEvictOption(func(k, v interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				_, ok := evicted[k]
+				req.False(ok, "evict callback is called twice")
+				evicted[k] = v
+			}),
+		)
+		for i := 0; i < 100; i++ {
+			m.Set(i, i)
+		}
+		req.Equal(100, m.Len())
+		req.Empty(evicted)
+
+		time.Sleep(2 * time.Millisecond)
+		for n := 0; n < 3; n++ {
+			for i := 0; i < 50; i++ {
+				_, ok := m.Get(i)
+				req.True(ok)
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+
+		// a newly inserted key is never evicted by its own insert
+		for i := 100; i < 150; i++ {
+			m.Set(i, i)
+			_, ok := m.Get(i)
+			req.True(ok)
+		}
+		req.Equal(100, m.Len())
+		req.Equal(50, len(evicted))
+
+		var hot, cold int
+		for k, v := range evicted {
+			req.Equal(k, v)
+			_, ok := m.Get(k)
+			req.False(ok)
+			if k.(int) < 50 {
+				hot++
+			} else {
+				cold++
+			}
+		}
+		req.True(test.check(hot, cold), "policy %T evicted %d hot and %d cold keys", test.policy, hot, cold)
+	}
+}
+
+func TestEvictSparse(t *testing.T) {
+	req := require.New(t)
+
+	// most buckets are empty, the few entries are spread over 1<<16 / 24
+	m := NewMap[int, int](CapacityOption(1<<16), MaxEntriesOption(100))
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+		req.LessOrEqual(m.Len(), 100)
+	}
+	req.Equal(100, m.Len())
+	_, ok := m.Get(9999)
+	req.True(ok)
+}
+
+func TestEvictConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8), MaxEntriesOption(1000))
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func(n int) {
+			for i := n * 10000; i < (n+1)*10000; i++ {
+				m.Set(i, i)
+			}
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+
+	// the capacity is approximate under concurrent writes
+	req.InDelta(1000, m.Len(), 20)
+	var total int
+	for k, v := range m.All() {
+		req.Equal(k, v)
+		total++
+	}
+	req.Equal(m.Len(), total)
+}
@@ -17,6 +17,7 @@ package hashmap
 import (
 	"math"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -26,19 +27,57 @@ type (
 		key  unsafe.Pointer
 		val  unsafe.Pointer
 		nxt  unsafe.Pointer
+		meta uint64 // eviction score, the lowest is evicted first
+	}
+
+	// entry is the value of a hashNode, an update replaces the whole entry so
+	// the value and its expiry always change together
+	entry[V any] struct {
+		val    V
+		expire int64     // deadline in unix nanoseconds, 0 means never expire
+		stamp  uint64    // epoch of the write, see snapshot
+		prev   *entry[V] // the replaced entry, kept while a snapshot is running
 	}
 )
 
+// markerVal is the value of marker nodes
+var markerVal = unsafe.Pointer(new(byte))
+
 func newFence() *hashNode {
 	return &hashNode{hash: math.MaxUint64}
 }
 
 func isFence(n *hashNode) bool {
-	return n.key == nil
+	return n.key == nil && n.val == nil
+}
+
+// isTail returns true if n is the last fence of the list
+func isTail(n *hashNode) bool {
+	return n.next() == nil
+}
+
+// isMarker returns true if n is the marker following a deleted node
+func isMarker(n *hashNode) bool {
+	return n.key == nil && n.val == markerVal
+}
+
+// mark links the node to a marker, so that CAS on its next pointer fails from
+// now on and the node can be safely unlinked
+func (n *hashNode) mark() {
+	for {
+		next := n.next()
+		if isMarker(next) {
+			return
+		}
+		marker := &hashNode{hash: n.hash, val: markerVal, nxt: unsafe.Pointer(next)}
+		if n.casNext(unsafe.Pointer(next), unsafe.Pointer(marker)) {
+			return
+		}
+	}
 }
 
 func (n *hashNode) linkTo(next *hashNode) {
-	n.nxt = unsafe.Pointer(next)
+	atomic.StorePointer(&n.nxt, unsafe.Pointer(next))
 }
 
 func (n *hashNode) next() *hashNode {
@@ -56,3 +95,8 @@ func (n *hashNode) casNext(expected, target unsafe.Pointer) bool {
 func (n *hashNode) casValue(expected, target unsafe.Pointer) bool {
 	return atomic.CompareAndSwapPointer(&n.val, expected, target)
 }
+
+// expired returns true if the entry has passed its deadline
+func (e *entry[V]) expired() bool {
+	return e.expire != 0 && e.expire <= time.Now().UnixNano()
+}
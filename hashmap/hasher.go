@@ -0,0 +1,155 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math/bits"
+
+	"github.com/dchest/siphash"
+)
+
+type (
+	// Hasher computes the 64-bit hash of the bytes of a key with a 128-bit
+	// seed. The seed varies with the key type, so keys of different types
+	// with the same bytes do not collide
+	Hasher interface {
+		Hash(k0, k1 uint64, p []byte) uint64
+	}
+
+	sipHasher struct{}
+	xxHasher  struct{}
+	mapHasher struct {
+		seed maphash.Seed
+	}
+)
+
+// built-in hashers
+var (
+	// SipHasher is the default hasher. SipHash is immune to hash-flooding,
+	// use it if keys may come from an untrusted source
+	SipHasher Hasher = sipHasher{}
+
+	// XXHasher implements the XXH64 algorithm, which is faster but not
+	// resistant to hash-flooding. Use it for trusted keys only
+	XXHasher Hasher = xxHasher{}
+
+	// MapHasher uses hash/maphash, the same hash as Go's native map. Its own
+	// seed is picked randomly when the program starts, so the hash is not
+	// reproducible even if SeedOption is set
+	MapHasher Hasher = mapHasher{seed: maphash.MakeSeed()}
+)
+
+// HasherOption sets the hasher of the map, SipHasher is used if not set
+func HasherOption(hs Hasher) Option {
+	return func(o *options) {
+		if hs != nil {
+			o.hasher = hs
+		}
+	}
+}
+
+// SeedOption sets the hash seed of the map, so the hash of a key and the bucket
+// layout are reproducible. A random seed is generated if not set
+func SeedOption(k0, k1 uint64) Option {
+	return func(o *options) {
+		o.seeded = true
+		o.seed0, o.seed1 = k0, k1
+	}
+}
+
+func (sipHasher) Hash(k0, k1 uint64, p []byte) uint64 {
+	return siphash.Hash(k0, k1, p)
+}
+
+func (hs mapHasher) Hash(k0, k1 uint64, p []byte) uint64 {
+	return maphash.Bytes(hs.seed, p) ^ xxAvalanche(k0^bits.RotateLeft64(k1, 32))
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func (xxHasher) Hash(k0, k1 uint64, p []byte) uint64 {
+	return xxh64(k0^bits.RotateLeft64(k1, 32), p)
+}
+
+// xxh64 computes the XXH64 hash of p
+func xxh64(seed uint64, p []byte) uint64 {
+	var (
+		n = len(p)
+		h uint64
+	)
+	if n >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; len(p) >= 32; p = p[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(p[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(p[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(p[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(p[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+
+	h += uint64(n)
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		h ^= uint64(b) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+	return xxAvalanche(h)
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMerge(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
+
+func xxAvalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXXH64(t *testing.T) {
+	req := require.New(t)
+
+	tests := []struct {
+		seed uint64
+		in   string
+		hash uint64
+	}{
+		{0, "", 0xef46db3751d8e999},
+		{0, "a", 0xd24ec4f1a98c6e5b},
+		{0, "abc", 0x44bc2cf5ad770999},
+		{0, "message digest", 0x066ed728fceeb3be},
+		{0, "abcdefghijklmnopqrstuvwxyz", 0xcfe1f278fa89835c},
+		{0, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", 0xaaa46907d3047814},
+		{0, strings.Repeat("1234567890", 8), 0xe04a477f19ee145d},
+	}
+	for _, test := range tests {
+		req.Equal(test.hash, xxh64(test.seed, []byte(test.in)), test.in)
+	}
+}
+
+func TestSeedOption(t *testing.T) {
+	req := require.New(t)
+
+	for _, hs := range []Hasher{SipHasher, XXHasher} {
+		m := NewMap[string, int](HasherOption(hs), SeedOption(1, 2))
+		m1 := NewMap[string, int](HasherOption(hs), SeedOption(1, 2))
+		m2 := NewMap[string, int](HasherOption(hs), SeedOption(2, 1))
+		for i := 0; i < 10000; i++ {
+			m.Set(strings.Repeat("k", i%50)+string(rune(i)), i)
+			m1.Set(strings.Repeat("k", i%50)+string(rune(i)), i)
+			m2.Set(strings.Repeat("k", i%50)+string(rune(i)), i)
+		}
+
+		// same seed yields same hash, hence same bucket layout and order
+		req.Equal(m.hash("key"), m1.hash("key"))
+		req.NotEqual(m.hash("key"), m2.hash("key"))
+		keys, keys1, keys2 := slices.Collect(m.Keys()), slices.Collect(m1.Keys()), slices.Collect(m2.Keys())
+		req.Equal(keys, keys1)
+		req.NotEqual(keys, keys2)
+		slices.Sort(keys)
+		slices.Sort(keys2)
+		req.Equal(keys, keys2)
+	}
+}
+
+func TestHasherOption(t *testing.T) {
+	req := require.New(t)
+
+	for _, hs := range []Hasher{SipHasher, XXHasher, MapHasher} {
+		m := New(HasherOption(hs))
+		for i := 0; i < 10000; i++ {
+			m.Set(i, i)
+			m.Set(int8(i), i)
+			m.Set(string(rune(i)), i)
+		}
+		req.Equal(10000+256+10000, m.Len())
+		for i := 0; i < 10000; i++ {
+			v, ok := m.Get(i)
+			req.True(ok)
+			req.Equal(i, v)
+			v, ok = m.Get(string(rune(i)))
+			req.True(ok)
+			req.Equal(i, v)
+		}
+
+		// same bytes of different key type yields different hash
+		req.NotEqual(m.hash(uint8(16)), m.hash(int8(16)))
+		req.NotEqual(m.hash(uint8(16)), m.hash(string([]byte{16})))
+	}
+}
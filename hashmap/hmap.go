@@ -17,210 +17,634 @@ package hashmap
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"math/bits"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 type (
-	hmap struct {
-		mutex   sync.RWMutex
-		bSize   uint8     // split once average bucket size reaches this
-		B       uint32    // log_2 of number of buckets (can hold up to loadFactor * 2^B items)
-		count   uint64    // number of items in the map
-		k0, k1  uint64    // hash seed
-		buckets []*bucket // array of 2^B Buckets
-		iter    int       // bucket index when ranging the map
-		curr    *hashNode // current node when ranging the map
+	// Map is a concurrent map[K]V, the same bucket/fence list serves both the
+	// type-safe and the interface{} based map
+	Map[K comparable, V any] struct {
+		options
+		mutex    sync.RWMutex                                 // held by the legacy Lock/Next/Unlock iteration, see gate
+		locked   uint32                                       // 1 while Lock is held
+		stats                                                 // counters reported by Stats
+		k0, k1   uint64                                       // hash seed
+		keyHash  func(hs Hasher, k0, k1 uint64, key K) uint64 // picked from K at construction
+		dir      unsafe.Pointer                               // *directory of the buckets, replaced as a whole on resize and Clear
+		resizing uint32                                       // 1 if a go-routine is resizing or clearing the buckets
+		initB    uint32                                       // start with 2^initB buckets
+		minB     uint32                                       // shrink no further than 2^minB buckets
+		maxB     uint32                                       // expand no further than 2^maxB buckets
+		iter     Iterator[K, V]                               // iterator when ranging the map under Lock()
+		running  uint32                                       // 1 if the janitor is running
+		ttlSet   uint32                                       // 1 if an entry with ttl is set since last sweep
+		epoch    uint64                                       // current epoch of writes, see snapshot
+		pins     [2]uint64                                    // number of running writes of even and odd epochs
+		snap     unsafe.Pointer                               // *snapshot that is running, nil if none
+		snapLock sync.Mutex                                   // only one snapshot runs at a time
+		watchers unsafe.Pointer                               // *watchers of Watch and WatchAll, nil if none
+		watchMu  sync.Mutex                                   // held to add or close a watcher
 	}
 
+	// directory is the array of 2^B buckets. It is immutable except that a nil
+	// bucket is initialized on first access, so readers load it without a lock
+	directory struct {
+		B       uint32           // log_2 of number of buckets (can hold up to loadFactor * 2^B items)
+		buckets []unsafe.Pointer // array of 2^B *bucket, nil until first accessed
+		count   *uint64          // number of items in the list, shared by the directories of resizes
+	}
+
+	// hmap is the map with interface{} key and value
+	hmap = Map[interface{}, interface{}]
+
 	// Hash64 returns 64-bit hash
 	Hash64 interface {
 		Sum64() uint64
 	}
+
+	options struct {
+		bSize      uint8                        // split once average bucket size reaches this
+		shrinkSize uint8                        // shrink once average bucket size drops to this
+		noShrink   bool                         // never shrink the buckets
+		capacity   int                          // number of entries to size the buckets for
+		maxBuckets int                          // upper limit of the number of buckets, 0 means unbounded
+		counters   bool                         // count Get/Set/Del calls
+		hasher     Hasher                       // hashes the bytes of a key
+		seeded     bool                         // use the given hash seed instead of a random one
+		seed0      uint64                       // the given hash seed
+		seed1      uint64                       // the given hash seed
+		ttl        time.Duration                // default ttl of entries, 0 means never expire
+		interval   time.Duration                // how often the janitor removes expired entries
+		onExpire   func(key, value interface{}) // called once an expired entry is dropped
+		maxEntries uint64                       // evict entries once the map holds more than this, 0 means unbounded
+		policy     EvictionPolicy               // picks the entry to evict
+		onEvict    func(key, value interface{}) // called once an entry is evicted
+	}
 )
 
 // Option provides options for instantiating HashMap
-type Option func(*hmap)
+type Option func(*options)
 
 // BucketSizeOption sets the average size of bucket
 func BucketSizeOption(size uint8) Option {
-	return func(h *hmap) {
-		h.bSize = size
+	return func(o *options) {
+		o.bSize = size
+	}
+}
+
+// CapacityOption sizes the buckets for n entries up front, so that loading them
+// does not expand the buckets again and again. The buckets never shrink below
+// this size
+func CapacityOption(n int) Option {
+	return func(o *options) {
+		o.capacity = n
+	}
+}
+
+// DisableShrinkOption keeps the buckets from shrinking when entries are deleted
+func DisableShrinkOption() Option {
+	return func(o *options) {
+		o.noShrink = true
+	}
+}
+
+// ShrinkThresholdOption sets the average bucket size at which the buckets are
+// halved, it defaults to 1/3 of the bucket size. It is capped below half of the
+// bucket size, so a shrink is not followed by an expand right away
+func ShrinkThresholdOption(size uint8) Option {
+	return func(o *options) {
+		o.shrinkSize = size
+	}
+}
+
+// MaxBucketsOption sets the upper limit of the number of buckets, which is
+// rounded down to a power of 2. Once reached, buckets grow longer instead
+func MaxBucketsOption(n int) Option {
+	return func(o *options) {
+		o.maxBuckets = n
+	}
+}
+
+// CountersOption counts the Get, Set and Del calls, and the CAS retries, reported
+// by Counters and Stats. It is off by default, as every call then updates a
+// shared counter
+func CountersOption() Option {
+	return func(o *options) {
+		o.counters = true
 	}
 }
 
 // New creates a new hashmap
 func New(opts ...Option) *hmap {
-	h := hmap{
-		bSize:   24,
-		buckets: make([]*bucket, 1),
+	return NewMap[interface{}, interface{}](opts...)
+}
+
+// NewMap creates a new type-safe hashmap
+func NewMap[K comparable, V any](opts ...Option) *Map[K, V] {
+	var h Map[K, V]
+	h.init(opts)
+	return &h
+}
+
+// init sets up an empty map with the options
+func (h *Map[K, V]) init(opts []Option) {
+	h.options = options{
+		bSize:    24,
+		hasher:   SipHasher,
+		interval: time.Second,
 	}
+	h.keyHash = hasherFor[K]()
 	for _, opt := range opts {
-		opt(&h)
+		opt(&h.options)
 	}
 	if h.bSize < 6 {
 		h.bSize = 6
 	}
+	if h.shrinkSize == 0 {
+		h.shrinkSize = h.bSize / 3
+	} else if h.shrinkSize > (h.bSize-1)/2 {
+		h.shrinkSize = (h.bSize - 1) / 2
+	}
+	h.maxB = 63
+	if h.maxBuckets > 0 {
+		h.maxB = uint32(bits.Len(uint(h.maxBuckets)) - 1)
+	}
+	for h.capacity > 0 && h.initB < h.maxB && uint64(h.capacity)>>h.initB > uint64(h.bSize) {
+		h.initB++
+	}
+	h.minB = max(h.initB, 4)
+	if h.maxEntries == 0 {
+		h.policy = nil
+	} else if h.policy == nil {
+		h.policy = LRU
+	}
+
+	if h.seeded {
+		h.k0, h.k1 = h.seed0, h.seed1
+	} else {
+		// generate 2 random seeds
+		binary.Read(rand.Reader, binary.BigEndian, &h.k0)
+		binary.Read(rand.Reader, binary.BigEndian, &h.k1)
+	}
 
-	// generate 2 random seeds
-	binary.Read(rand.Reader, binary.BigEndian, &h.k0)
-	binary.Read(rand.Reader, binary.BigEndian, &h.k1)
+	h.dir = unsafe.Pointer(h.newDirectory())
+}
 
+// newDirectory creates the directory of a new empty list
+func (h *Map[K, V]) newDirectory() *directory {
 	// create the very first bucket
-	h.buckets[0] = newBucket(0, 0)
-	h.buckets[0].fence.linkTo(newFence())
-	return &h
+	b := newBucket[K, V](0)
+	b.fence.linkTo(newFence())
+	buckets := make([]unsafe.Pointer, 1<<h.initB)
+	buckets[0] = unsafe.Pointer(b)
+	return &directory{
+		B:       h.initB,
+		buckets: buckets,
+		count:   new(uint64),
+	}
 }
 
-func (h *hmap) Len() int {
-	return int(atomic.LoadUint64(&h.count))
+// Len returns the number of entries. An expired entry is counted until a read
+// finds it or the janitor removes it
+func (h *Map[K, V]) Len() int {
+	return int(atomic.LoadUint64(h.directory().count))
 }
 
-func (h *hmap) Get(key interface{}) (interface{}, bool) {
+func (h *Map[K, V]) Get(key K) (V, bool) {
+	h.gate()
+	h.tally(&h.gets)
 	hash := h.hash(key)
-	return h.getBucket(hash).get(key, hash)
+	node := h.getBucket(hash).get(key, hash, nil)
+	if node != nil {
+		if e := (*entry[V])(node.value()); e != nil {
+			if !e.expired() {
+				h.touch(node)
+				return e.val, true
+			}
+			// remove it now rather than wait for the janitor, so Len no
+			// longer counts it
+			h.purge(node, e)
+		}
+	}
+	var v V
+	return v, false
+}
+
+func (h *Map[K, V]) Set(key K, value V) {
+	h.swap(key, h.newEntry(value, h.ttl))
+}
+
+// Swap sets the value for a key, and returns the previous value if any. The
+// loaded result reports whether the key was present
+func (h *Map[K, V]) Swap(key K, value V) (V, bool) {
+	return h.swap(key, h.newEntry(value, h.ttl))
+}
+
+func (h *Map[K, V]) swap(key K, e *entry[V]) (V, bool) {
+	h.tally(&h.sets)
+	key = cloneKey(key)
+	hash := h.hash(key)
+	node := hashNode{
+		hash: hash,
+		key:  unsafe.Pointer(&key),
+		val:  unsafe.Pointer(e),
+		meta: h.score(),
+	}
+	curr, old, _ := h.storeNode(&node, nil, nil)
+	h.touch(curr)
+	return h.stored(curr, old)
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored
+func (h *Map[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	return h.loadOrStore(key, h.newEntry(value, h.ttl))
+}
+
+func (h *Map[K, V]) loadOrStore(key K, e *entry[V]) (V, bool) {
+	h.tally(&h.sets)
+	key = cloneKey(key)
+	hash := h.hash(key)
+	node := hashNode{
+		hash: hash,
+		key:  unsafe.Pointer(&key),
+		val:  unsafe.Pointer(e),
+		meta: h.score(),
+	}
+	curr, actual, stored := h.storeNode(&node, func(e *entry[V]) bool {
+		return e == nil || e.expired()
+	}, nil)
+	h.touch(curr)
+	if !stored {
+		return actual.val, true
+	}
+	h.stored(curr, actual)
+	return e.val, false
 }
 
-func (h *hmap) Set(key, value interface{}) {
+// CompareAndSwap swaps the old and new values for key if the value stored in
+// the map is equal to old. The old value must be of a comparable type
+func (h *Map[K, V]) CompareAndSwap(key K, old, new V) bool {
+	h.tally(&h.sets)
 	hash := h.hash(key)
 	node := hashNode{
 		hash: hash,
 		key:  unsafe.Pointer(&key),
-		val:  unsafe.Pointer(&value),
+		val:  unsafe.Pointer(h.newEntry(new, h.ttl)),
 	}
-	if h.getBucket(hash).upsert(&node) {
-		atomic.AddUint64(&h.count, 1)
+	curr, _, stored := h.storeNode(&node, func(e *entry[V]) bool {
+		return e != nil && !e.expired() && any(e.val) == any(old)
+	}, nil)
+	if stored {
+		h.touch(curr)
 	}
+	return stored
+}
 
-	if h.isOverflow() {
+// Compute sets the value of key to the result of f, which is called with the
+// current value and whether the key is present. The key is deleted if f returns
+// false. f is called again if the value changes before the result is stored
+// with CAS, so it may be called more than once. It returns the new value and
+// whether the key is present
+func (h *Map[K, V]) Compute(key K, f func(old V, present bool) (V, bool)) (V, bool) {
+	return h.compute(key, func(old V, present bool) (V, computeOp) {
+		if value, keep := f(old, present); keep {
+			return value, computeStore
+		}
+		return old, computeDelete
+	})
+}
+
+// results of the function of compute
+const (
+	computeDelete computeOp = iota // deletes the key
+	computeStore                   // stores the value
+	computeLeave                   // leaves the entry as is, with no write
+)
+
+type computeOp uint8
+
+// compute is Compute, except that f can also leave the entry as is
+func (h *Map[K, V]) compute(key K, f func(old V, present bool) (V, computeOp)) (V, bool) {
+	key = cloneKey(key)
+	hash := h.hash(key)
+	node := hashNode{
+		hash: hash,
+		key:  unsafe.Pointer(&key),
+	}
+	for {
+		e := h.getBucket(hash).lookup(&node)
+
+		// f is called without holding any lock, so it can access the map
+		var old V
+		present := e != nil && !e.expired()
+		if present {
+			old = e.val
+		}
+		value, op := f(old, present)
+		if op == computeLeave {
+			return old, present
+		}
+		unchanged := func(curr *entry[V]) bool {
+			return curr == e
+		}
+
+		if op == computeStore {
+			node.val = unsafe.Pointer(h.newEntry(value, h.ttl))
+			node.meta = h.score()
+			curr, _, stored := h.storeNode(&node, unchanged, nil)
+			if !stored {
+				continue
+			}
+			h.tally(&h.sets)
+			h.touch(curr)
+			h.stored(curr, e)
+			return value, true
+		}
+
+		if e != nil {
+			_, deleted := h.removeNode(&node, unchanged, nil)
+			if !deleted {
+				continue
+			}
+			h.tally(&h.dels)
+			h.removed(&key, e)
+		}
+		var v V
+		return v, false
+	}
+}
+
+// ComputeIfAbsent returns the existing value for the key if present. Otherwise,
+// it stores and returns the result of f. The loaded result is true if the value
+// was loaded. f is only called if the key is absent, but its result is dropped
+// if another goroutine stores the key first
+func (h *Map[K, V]) ComputeIfAbsent(key K, f func() V) (V, bool) {
+	if v, ok := h.Get(key); ok {
+		return v, true
+	}
+	return h.LoadOrStore(key, f())
+}
+
+// Merge stores the value if the key is absent. Otherwise, it stores the result
+// of f called with the current value and the given value, or deletes the key
+// if f returns false. It returns the new value and whether the key is present
+func (h *Map[K, V]) Merge(key K, value V, f func(old, value V) (V, bool)) (V, bool) {
+	return h.Compute(key, func(old V, present bool) (V, bool) {
+		if !present {
+			return value, true
+		}
+		return f(old, value)
+	})
+}
+
+// stored accounts for the entry replaced by a write into node, nil if the node
+// is newly inserted, and returns its value if it has not expired
+func (h *Map[K, V]) stored(node *hashNode, old *entry[V]) (V, bool) {
+	if old == nil && h.isOverflow() {
 		h.expand()
 	}
+	return h.replaced(node, old)
 }
 
-func (h *hmap) isOverflow() bool {
-	return atomic.LoadUint64(&h.count)>>atomic.LoadUint32(&h.B) > uint64(h.bSize)
+// replaced is stored without expanding the map, which a batch does once at the
+// end. It evicts other entries if needed after a new node is inserted
+func (h *Map[K, V]) replaced(node *hashNode, old *entry[V]) (V, bool) {
+	var v V
+	if old == nil {
+		if h.maxEntries > 0 {
+			h.evict(node)
+		}
+		return v, false
+	}
+	if old.expired() {
+		h.expired((*K)(node.key), old)
+		return v, false
+	}
+	return old.val, true
 }
 
-func (h *hmap) Del(key interface{}) {
+func (h *Map[K, V]) isOverflow() bool {
+	d := h.directory()
+	return d.B < h.maxB && atomic.LoadUint64(d.count)>>d.B > uint64(h.bSize)
+}
+
+func (h *Map[K, V]) Del(key K) {
+	h.LoadAndDelete(key)
+}
+
+// LoadAndDelete deletes the value for a key, and returns the previous value if
+// any. The loaded result reports whether the key was present
+func (h *Map[K, V]) LoadAndDelete(key K) (V, bool) {
+	return h.remove(key, nil)
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old. The
+// old value must be of a comparable type
+func (h *Map[K, V]) CompareAndDelete(key K, old V) bool {
+	_, deleted := h.remove(key, func(e *entry[V]) bool {
+		return !e.expired() && any(e.val) == any(old)
+	})
+	return deleted
+}
+
+// remove deletes the key if cond is nil or returns true on its entry
+func (h *Map[K, V]) remove(key K, cond func(*entry[V]) bool) (V, bool) {
+	h.tally(&h.dels)
 	hash := h.hash(key)
 	node := hashNode{
 		hash: hash,
 		key:  unsafe.Pointer(&key),
 	}
-	if h.getBucket(hash).del(&node) {
-		atomic.AddUint64(&h.count, ^uint64(0))
+	e, deleted := h.removeNode(&node, cond, nil)
+	if !deleted {
+		var v V
+		return v, false
 	}
+	return h.removed(&key, e)
+}
 
+// removed accounts for the entry of a deleted key, and returns its value if it
+// has not expired
+func (h *Map[K, V]) removed(key *K, e *entry[V]) (V, bool) {
+	h.deleted()
+	return h.dropped(key, e)
+}
+
+// dropped is removed without shrinking the map, which a batch does once at the
+// end
+func (h *Map[K, V]) dropped(key *K, e *entry[V]) (V, bool) {
+	if e.expired() {
+		h.expired(key, e)
+		var v V
+		return v, false
+	}
+	return e.val, true
+}
+
+// deleted shrinks the map if needed, after a key is removed
+func (h *Map[K, V]) deleted() {
 	if h.isUnderflow() {
 		h.shrink()
 	}
 }
 
-func (h *hmap) isUnderflow() bool {
-	B := atomic.LoadUint32(&h.B)
-	return B > 4 && (atomic.LoadUint64(&h.count)>>B) <= uint64(h.bSize/3)
+func (h *Map[K, V]) isUnderflow() bool {
+	d := h.directory()
+	return !h.noShrink && d.B > h.minB && (atomic.LoadUint64(d.count)>>d.B) <= uint64(h.shrinkSize)
 }
 
-func (h *hmap) Lock() {
+// Clear removes all entries at once, by replacing the buckets with empty ones.
+// A write running at the same time may land in the old buckets and be dropped
+// with them, as if it were done before Clear. The removed entries send no
+// events to watchers, and OnExpire and OnEvict are not called for them
+func (h *Map[K, V]) Clear() {
+	epoch, _ := h.pin()
+	defer h.unpin(epoch)
+	for !atomic.CompareAndSwapUint32(&h.resizing, 0, 1) {
+		// a resize is about to publish a directory of the old buckets
+		runtime.Gosched()
+	}
+	defer atomic.StoreUint32(&h.resizing, 0)
+	atomic.StorePointer(&h.dir, unsafe.Pointer(h.newDirectory()))
+}
+
+// Reset clears the map, and zeroes the counters reported by Counters and Stats
+func (h *Map[K, V]) Reset() {
+	h.Clear()
+	h.resetCounters()
+}
+
+// Lock starts the legacy iteration with Next. Reads and writes of the map wait
+// until Unlock, so the map must not be used by the caller in between. Iter and
+// Range walk the map without blocking it
+func (h *Map[K, V]) Lock() {
 	h.mutex.Lock()
-	h.iter = 0
-	h.curr = &h.buckets[0].fence
+	atomic.StoreUint32(&h.locked, 1)
+	// writes check locked once pinned, so they either wait in pin or are
+	// done once the pins drop to 0
+	for atomic.LoadUint64(&h.pins[0])+atomic.LoadUint64(&h.pins[1]) != 0 {
+		runtime.Gosched()
+	}
+	h.iter.curr = &h.getBucket(0).fence
 }
 
-func (h *hmap) Unlock() {
+// Unlock ends the legacy iteration started by Lock
+func (h *Map[K, V]) Unlock() {
+	atomic.StoreUint32(&h.locked, 0)
 	h.mutex.Unlock()
 }
 
-func (h *hmap) Next() (interface{}, interface{}, bool) {
-	next := h.curr.next()
-	if !isFence(next) {
-		h.curr = next
-		return *(*interface{})(next.key), *(*interface{})(next.value()), true
+// gate waits for Unlock while Lock is held
+func (h *Map[K, V]) gate() {
+	if atomic.LoadUint32(&h.locked) != 0 {
+		h.mutex.RLock()
+		h.mutex.RUnlock()
 	}
-	if h.iter == 1<<h.B-1 {
-		return nil, nil, false
-	}
-	h.iter++
-	h.curr = next
-	return h.Next()
+}
 
+// Next returns next <k, v> of the legacy iteration started by Lock
+func (h *Map[K, V]) Next() (K, V, bool) {
+	return h.iter.Next()
 }
 
-func (h *hmap) getBucket(hash uint64) *bucket {
-	h.mutex.RLock()
-	b := h.buckets[hash>>(64-h.B)]
-	h.mutex.RUnlock()
+func (h *Map[K, V]) hash(key K) uint64 {
+	return h.keyHash(h.hasher, h.k0, h.k1, key)
+}
+
+// directory returns the current directory of the buckets
+func (h *Map[K, V]) directory() *directory {
+	return (*directory)(atomic.LoadPointer(&h.dir))
+}
+
+// getBucket returns the bucket of the hash
+func (h *Map[K, V]) getBucket(hash uint64) *bucket[K, V] {
+	d := h.directory()
+	return h.bucketAt(d, hash>>(64-d.B))
+}
+
+// bucketAt returns the i-th bucket. A bucket is initialized on first access by
+// inserting its fence after the fence of its parent bucket, which is i with
+// the lowest 1-bit cleared
+func (h *Map[K, V]) bucketAt(d *directory, i uint64) *bucket[K, V] {
+	if b := atomic.LoadPointer(&d.buckets[i]); b != nil {
+		return (*bucket[K, V])(b)
+	}
+	b := h.bucketAt(d, i&(i-1)).split(i << (64 - d.B))
+	if !atomic.CompareAndSwapPointer(&d.buckets[i], nil, unsafe.Pointer(b)) {
+		// initialized by another go-routine, with the same fence
+		return (*bucket[K, V])(atomic.LoadPointer(&d.buckets[i]))
+	}
 	return b
 }
 
-func (h *hmap) expand() {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
+// expand doubles the buckets. It only publishes a new directory where the i-th
+// bucket moves to 2i-th position, the (2i+1)-th bucket is left for bucketAt
+// to split from it later
+//
+// [00, 01, 10, 11] --> [000, x, 010, x, 100, x, 110, x]
+//
+// It returns false if the map is no longer overflowing, or another go-routine
+// is resizing it
+func (h *Map[K, V]) expand() bool {
+	if !atomic.CompareAndSwapUint32(&h.resizing, 0, 1) {
+		return false
+	}
+	defer atomic.StoreUint32(&h.resizing, 0)
 	if !h.isOverflow() {
-		return
+		return false
 	}
 
-	// double the buckets list
-	h.buckets = append(h.buckets, h.buckets...)
+	defer h.resized(time.Now(), &h.expands)
 
-	// move i-th item to 2i-th position --> [00, x, 01, x, 10, x, 11, x]
-	// then split the buckets
-	// [00, x, 01, x, 10, x, 11, x] --> [000, 001, 010, 011, 100, 101, 110, 111]
-	atomic.AddUint32(&h.B, 1)
-	for i := len(h.buckets)/2 - 1; i >= 0; i-- {
-		if i != 0 {
-			h.buckets[2*i] = nil
-			h.buckets[2*i] = h.buckets[i]
-		}
-		h.buckets[2*i+1] = nil
-		h.buckets[2*i+1] = h.buckets[2*i].split(uint64(2*i+1) << (64 - h.B))
+	d := h.directory()
+	buckets := make([]unsafe.Pointer, 2*len(d.buckets))
+	for i := range d.buckets {
+		buckets[2*i] = atomic.LoadPointer(&d.buckets[i])
 	}
+	h.publish(d, d.B+1, buckets)
+	return true
 }
 
-func (h *hmap) shrink() {
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
+// shrink halves the buckets. The fence of an odd bucket stays in the list, and
+// is reused once the buckets expand again
+//
+// [000, 001, 010, 011, 100, 101, 110, 111] --> [00, 01, 10, 11]
+//
+// It returns false like expand
+func (h *Map[K, V]) shrink() bool {
+	if !atomic.CompareAndSwapUint32(&h.resizing, 0, 1) {
+		return false
+	}
+	defer atomic.StoreUint32(&h.resizing, 0)
 	if !h.isUnderflow() {
-		return
-	}
-
-	// merge the buckets
-	// [000, 001, 010, 011, 100, 101, 110, 111] --> [00, x, 01, x, 10, x, 11, x]
-	// then halve the list
-	// [00, x, 01, x, 10, x, 11, x] --> [00, 01, 10, 11]
-	half := len(h.buckets) / 2
-	for i := 0; i < half; i++ {
-		h.buckets[2*i].merge(h.buckets[2*i+1])
-		h.buckets[2*i+1] = nil
-		if i != 0 {
-			h.buckets[i] = nil
-			h.buckets[i] = h.buckets[2*i]
-		}
+		return false
 	}
-	atomic.AddUint32(&h.B, ^uint32(0))
-	h.buckets = h.buckets[:half]
-}
 
-func (h *hmap) info() {
-	var count, min, max uint32
-	min = 1<<32 - 1
-	for i := 0; i < (1 << h.B); i++ {
-		b := h.buckets[i]
-		count += b.count
-		if b.count < min {
-			min = b.count
-		}
-		if b.count > max {
-			max = b.count
-		}
+	defer h.resized(time.Now(), &h.shrinks)
+
+	d := h.directory()
+	buckets := make([]unsafe.Pointer, len(d.buckets)/2)
+	for i := range buckets {
+		buckets[i] = atomic.LoadPointer(&d.buckets[2*i])
 	}
-	println("++==========================")
-	println("|| total key count =", h.count)
-	println("|| bucket number =", 1<<h.B)
-	println("|| key per bucket =", h.count>>h.B)
-	println("|| total key count =", count)
-	println("|| min keys per bucket =", min)
-	println("|| max keys per bucket =", max)
-	println("++==========================")
+	h.publish(d, d.B-1, buckets)
+	return true
+}
+
+// publish replaces the old directory of the same list. A bucket initialized in
+// the old directory after it is copied is initialized again in the new one, and
+// finds its fence already in the list
+func (h *Map[K, V]) publish(old *directory, B uint32, buckets []unsafe.Pointer) {
+	atomic.StorePointer(&h.dir, unsafe.Pointer(&directory{
+		B:       B,
+		buckets: buckets,
+		count:   old.count,
+	}))
 }
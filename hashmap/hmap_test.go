@@ -15,7 +15,13 @@
 package hashmap
 
 import (
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -96,5 +102,622 @@ func TestHmap(t *testing.T) {
 	m.Unlock()
 	req.Equal(len(tests)-1, match)
 	req.Equal(10000+len(tests)-1, total)
-	m.info()
+	req.Equal(total, m.Stats().Count)
+}
+
+func TestMap(t *testing.T) {
+	req := require.New(t)
+
+	type id int
+	m := NewMap[id, string](BucketSizeOption(8))
+	for i := 0; i < 10000; i++ {
+		m.Set(id(i), strconv.Itoa(i))
+	}
+	req.Equal(10000, m.Len())
+	for i := 0; i < 10000; i++ {
+		v, ok := m.Get(id(i))
+		req.True(ok)
+		req.Equal(strconv.Itoa(i), v)
+	}
+	v, ok := m.Get(10000)
+	req.False(ok)
+	req.Empty(v)
+
+	// update and delete
+	m.Set(0, "zero")
+	v, _ = m.Get(0)
+	req.Equal("zero", v)
+	for i := 0; i < 5000; i++ {
+		m.Del(id(i))
+	}
+	req.Equal(5000, m.Len())
+
+	m.Lock()
+	var total int
+	for k, v, ok := m.Next(); ok; k, v, ok = m.Next() {
+		req.True(k >= 5000)
+		req.Equal(strconv.Itoa(int(k)), v)
+		total++
+	}
+	m.Unlock()
+	req.Equal(5000, total)
+}
+
+func TestComparableKeys(t *testing.T) {
+	req := require.New(t)
+
+	type tenant struct {
+		name string
+		id   int
+	}
+	m := NewMap[tenant, int](BucketSizeOption(8))
+	for i := 0; i < 1000; i++ {
+		m.Set(tenant{strconv.Itoa(i % 10), i}, i)
+	}
+	req.Equal(1000, m.Len())
+	for i := 0; i < 1000; i++ {
+		v, ok := m.Get(tenant{strconv.Itoa(i % 10), i})
+		req.True(ok)
+		req.Equal(i, v)
+	}
+	_, ok := m.Get(tenant{"1", 2})
+	req.False(ok)
+
+	// different key types in the same map
+	var (
+		h   = NewMap[interface{}, interface{}]()
+		p   = new(int)
+		arr = [16]byte{1, 2, 3}
+	)
+	h.Set(tenant{"a", 1}, 1)
+	h.Set(arr, 2)
+	h.Set(p, 3)
+	h.Set(1.5, 4)
+	h.Set(true, 5)
+	h.Set(0.0, 6)
+	for k, v := range map[interface{}]interface{}{
+		tenant{"a", 1}:       1,
+		[16]byte{1, 2, 3}:    2,
+		p:                    3,
+		1.5:                  4,
+		true:                 5,
+		math.Copysign(0, -1): 6,
+	} {
+		val, ok := h.Get(k)
+		req.True(ok)
+		req.Equal(v, val)
+	}
+	_, ok = h.Get(new(int))
+	req.False(ok)
+	req.Equal(6, h.Len())
+}
+
+// collidingHasher puts all keys but uint64 into the same hash
+type collidingHasher struct{}
+
+func (collidingHasher) Hash(k0, k1 uint64, p []byte) uint64 {
+	return 42
+}
+
+func TestBytesKey(t *testing.T) {
+	req := require.New(t)
+
+	for _, hs := range []Hasher{SipHasher, collidingHasher{}} {
+		m := New(HasherOption(hs), BucketSizeOption(4))
+		for i := 0; i < 1000; i++ {
+			m.Set([]byte(strconv.Itoa(i)), i)
+		}
+		// string with the same content is a different key
+		m.Set("1", "one")
+		req.Equal(1001, m.Len())
+		for i := 0; i < 1000; i++ {
+			v, ok := m.Get([]byte(strconv.Itoa(i)))
+			req.True(ok)
+			req.Equal(i, v)
+		}
+		v, ok := m.Get("1")
+		req.True(ok)
+		req.Equal("one", v)
+		_, ok = m.Get([]byte("1000"))
+		req.False(ok)
+
+		// the map keeps its own copy of the key
+		key := []byte("key")
+		m.Set(key, 1)
+		key[0] = 'K'
+		v, ok = m.Get([]byte("key"))
+		req.True(ok)
+		req.Equal(1, v)
+		_, ok = m.Get(key)
+		req.False(ok)
+		key = []byte("new")
+		m.LoadOrStore(key, 2)
+		m.Compute([]byte("compute"), func(interface{}, bool) (interface{}, bool) {
+			return 3, true
+		})
+		key[0] = 'N'
+		v, ok = m.Get([]byte("new"))
+		req.True(ok)
+		req.Equal(2, v)
+
+		// update and delete by content
+		m.Set([]byte("key"), 4)
+		v, _ = m.Get([]byte("key"))
+		req.Equal(4, v)
+		req.False(m.CompareAndDelete([]byte("key"), 1))
+		req.True(m.CompareAndDelete([]byte("key"), 4))
+		v, ok = m.LoadAndDelete([]byte("new"))
+		req.True(ok)
+		req.Equal(2, v)
+		m.Del([]byte("compute"))
+		for i := 0; i < 1000; i++ {
+			m.Del([]byte(strconv.Itoa(i)))
+		}
+		req.Equal(1, m.Len())
+		v, ok = m.Get("1")
+		req.True(ok)
+		req.Equal("one", v)
+	}
+}
+
+func TestAtomicOps(t *testing.T) {
+	req := require.New(t)
+
+	m := New()
+	v, loaded := m.LoadOrStore("a", 1)
+	req.False(loaded)
+	req.Equal(1, v)
+	v, loaded = m.LoadOrStore("a", 2)
+	req.True(loaded)
+	req.Equal(1, v)
+
+	v, loaded = m.Swap("a", 3)
+	req.True(loaded)
+	req.Equal(1, v)
+	v, loaded = m.Swap("b", 4)
+	req.False(loaded)
+	req.Nil(v)
+	req.Equal(2, m.Len())
+
+	req.False(m.CompareAndSwap("a", 1, 5))
+	req.True(m.CompareAndSwap("a", 3, 5))
+	req.False(m.CompareAndSwap("c", nil, 5))
+	v, _ = m.Get("a")
+	req.Equal(5, v)
+
+	req.False(m.CompareAndDelete("a", 3))
+	req.False(m.CompareAndDelete("c", 3))
+	req.True(m.CompareAndDelete("a", 5))
+	_, ok := m.Get("a")
+	req.False(ok)
+	req.Equal(1, m.Len())
+
+	v, loaded = m.LoadAndDelete("b")
+	req.True(loaded)
+	req.Equal(4, v)
+	v, loaded = m.LoadAndDelete("b")
+	req.False(loaded)
+	req.Nil(v)
+	req.Zero(m.Len())
+
+	// uncomparable old value panics, like sync.Map
+	m.Set("s", []int{1})
+	req.Panics(func() { m.CompareAndSwap("s", []int{1}, nil) })
+}
+
+func TestAtomicOpsConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	var (
+		wg     sync.WaitGroup
+		stored = make([]int, 8)
+	)
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func(n int) {
+			for i := 0; i < 1000; i++ {
+				// only 1 goroutine stores each key
+				if _, loaded := m.LoadOrStore(i, 0); !loaded {
+					stored[n]++
+				}
+				// every goroutine increments each key exactly once
+				for {
+					v, _ := m.Get(i)
+					if m.CompareAndSwap(i, v, v+1) {
+						break
+					}
+				}
+			}
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+
+	var total int
+	for n := range stored {
+		total += stored[n]
+	}
+	req.Equal(1000, total)
+	req.Equal(1000, m.Len())
+	for i := 0; i < 1000; i++ {
+		v, _ := m.Get(i)
+		req.Equal(8, v)
+	}
+}
+
+func TestDelConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	var (
+		wg      sync.WaitGroup
+		deleted = make([]int, 8)
+	)
+	wg.Add(16)
+	for n := 0; n < 8; n++ {
+		// every key is deleted by exactly 1 goroutine
+		go func(n int) {
+			for i := 0; i < 10000; i++ {
+				if _, ok := m.LoadAndDelete(i); ok {
+					deleted[n]++
+				}
+			}
+			wg.Done()
+		}(n)
+		// readers and writers of other keys run meanwhile
+		go func(n int) {
+			for i := 0; i < 10000; i++ {
+				k := 10000 + n*10000 + i
+				m.Set(k, k)
+				v, ok := m.Get(k)
+				if !ok || v != k {
+					t.Errorf("key %d = %d, %v", k, v, ok)
+				}
+				if i%2 == 0 {
+					m.Del(k)
+				}
+			}
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+
+	var total int
+	for n := range deleted {
+		total += deleted[n]
+	}
+	req.Equal(10000, total)
+	req.Equal(40000, m.Len())
+	for k, v := range m.All() {
+		req.True(k >= 10000 && k%2 == 1)
+		req.Equal(k, v)
+		total++
+	}
+	req.Equal(50000, total)
+}
+
+func TestResizeConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	var wg sync.WaitGroup
+	for round := 0; round < 2; round++ {
+		// expand while writing and reading
+		wg.Add(8)
+		for n := 0; n < 8; n++ {
+			go func(n int) {
+				for i := n * 10000; i < (n+1)*10000; i++ {
+					m.Set(i, i)
+					if v, ok := m.Get(i); !ok || v != i {
+						t.Errorf("key %d = %d, %v", i, v, ok)
+					}
+				}
+				wg.Done()
+			}(n)
+		}
+		wg.Wait()
+		req.Equal(80000, m.Len())
+		req.True(m.directory().B >= 13)
+		for i := 0; i < 80000; i++ {
+			v, ok := m.Get(i)
+			req.True(ok)
+			req.Equal(i, v)
+		}
+
+		// shrink while deleting and reading
+		wg.Add(8)
+		for n := 0; n < 8; n++ {
+			go func(n int) {
+				for i := n * 10000; i < (n+1)*10000; i++ {
+					if i%100 != 0 {
+						m.Del(i)
+					}
+					if v, ok := m.Get(i - i%100); !ok || v != i-i%100 {
+						t.Errorf("key %d = %d, %v", i, v, ok)
+					}
+				}
+				wg.Done()
+			}(n)
+		}
+		wg.Wait()
+		req.Equal(800, m.Len())
+		req.True(m.directory().B <= 9)
+		var total int
+		for k, v := range m.All() {
+			req.Zero(k % 100)
+			req.Equal(k, v)
+			total++
+		}
+		req.Equal(800, total)
+	}
+}
+
+func TestResizeOptions(t *testing.T) {
+	req := require.New(t)
+
+	// the buckets are sized for the capacity up front
+	m := NewMap[int, int](BucketSizeOption(8), CapacityOption(100000))
+	req.EqualValues(14, m.directory().B)
+	for i := 0; i < 100000; i++ {
+		m.Set(i, i)
+	}
+	req.EqualValues(14, m.directory().B)
+	for i := 0; i < 100000; i++ {
+		v, ok := m.Get(i)
+		req.True(ok)
+		req.Equal(i, v)
+	}
+	// and never shrink below that
+	for i := 0; i < 100000; i++ {
+		m.Del(i)
+	}
+	req.Zero(m.Len())
+	req.EqualValues(14, m.directory().B)
+
+	// shrink is disabled
+	m = NewMap[int, int](BucketSizeOption(8), DisableShrinkOption())
+	for i := 0; i < 100000; i++ {
+		m.Set(i, i)
+	}
+	req.EqualValues(14, m.directory().B)
+	for i := 0; i < 100000; i++ {
+		m.Del(i)
+	}
+	req.EqualValues(14, m.directory().B)
+
+	// shrink once the average bucket size drops to 1
+	m = NewMap[int, int](BucketSizeOption(8), ShrinkThresholdOption(1))
+	for i := 0; i < 100000; i++ {
+		m.Set(i, i)
+	}
+	for i := 0; i < 100000-(2<<14); i++ {
+		m.Del(i)
+	}
+	req.Equal(2<<14, m.Len())
+	req.EqualValues(14, m.directory().B)
+	m.Del(100000 - (2 << 14))
+	req.EqualValues(13, m.directory().B)
+	// the threshold is capped below half of the bucket size
+	m = NewMap[int, int](BucketSizeOption(8), ShrinkThresholdOption(8))
+	req.EqualValues(3, m.shrinkSize)
+
+	// the buckets stop growing at the limit
+	m = NewMap[int, int](BucketSizeOption(8), MaxBucketsOption(1000), CapacityOption(100000))
+	req.EqualValues(9, m.directory().B)
+	for i := 0; i < 100000; i++ {
+		m.Set(i, i)
+	}
+	req.EqualValues(9, m.directory().B)
+	for i := 0; i < 100000; i++ {
+		v, ok := m.Get(i)
+		req.True(ok)
+		req.Equal(i, v)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[string, int]()
+	incr := func(old int, present bool) (int, bool) {
+		return old + 1, true
+	}
+	v, ok := m.Compute("a", incr)
+	req.True(ok)
+	req.Equal(1, v)
+	v, ok = m.Compute("a", incr)
+	req.True(ok)
+	req.Equal(2, v)
+	req.Equal(1, m.Len())
+
+	// keep = false deletes the key
+	v, ok = m.Compute("a", func(old int, present bool) (int, bool) {
+		req.True(present)
+		req.Equal(2, old)
+		return 0, false
+	})
+	req.False(ok)
+	req.Zero(v)
+	_, ok = m.Get("a")
+	req.False(ok)
+	req.Zero(m.Len())
+
+	// nothing to delete
+	v, ok = m.Compute("b", func(old int, present bool) (int, bool) {
+		req.False(present)
+		return 0, false
+	})
+	req.False(ok)
+	req.Zero(m.Len())
+
+	// f can access the map
+	m.Set("c", 3)
+	v, ok = m.Compute("d", func(old int, present bool) (int, bool) {
+		c, _ := m.Get("c")
+		return c + 1, true
+	})
+	req.True(ok)
+	req.Equal(4, v)
+
+	v, loaded := m.ComputeIfAbsent("d", func() int {
+		panic("should not be called")
+	})
+	req.True(loaded)
+	req.Equal(4, v)
+	v, loaded = m.ComputeIfAbsent("e", func() int { return 5 })
+	req.False(loaded)
+	req.Equal(5, v)
+
+	sum := func(old, value int) (int, bool) {
+		return old + value, old+value != 0
+	}
+	v, ok = m.Merge("f", 6, sum)
+	req.True(ok)
+	req.Equal(6, v)
+	v, ok = m.Merge("f", 6, sum)
+	req.True(ok)
+	req.Equal(12, v)
+	v, ok = m.Merge("f", -12, sum)
+	req.False(ok)
+	_, ok = m.Get("f")
+	req.False(ok)
+	req.Equal(3, m.Len())
+}
+
+func TestComputeConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, []int](BucketSizeOption(8))
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func(n int) {
+			for i := 0; i < 1000; i++ {
+				// append to a copy, so no update is lost
+				m.Compute(i, func(old []int, present bool) ([]int, bool) {
+					return append(append([]int(nil), old...), n), true
+				})
+			}
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+
+	req.Equal(1000, m.Len())
+	for i := 0; i < 1000; i++ {
+		v, _ := m.Get(i)
+		req.Len(v, 8)
+	}
+
+	// concurrently delete every key once it reaches 8 increments
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func() {
+			for i := 0; i < 1000; i++ {
+				m.Compute(i, func(old []int, present bool) ([]int, bool) {
+					if !present || len(old) == 1 {
+						return nil, false
+					}
+					return old[1:], true
+				})
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	req.Zero(m.Len())
+}
+
+func TestLock(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int]()
+	m.Set(1, 1)
+
+	// reads and writes wait for Unlock
+	m.Lock()
+	var set, got, cleared int32
+	go func() {
+		m.Set(2, 2)
+		atomic.StoreInt32(&set, 1)
+	}()
+	go func() {
+		m.Get(1)
+		atomic.StoreInt32(&got, 1)
+	}()
+	go func() {
+		m.Clear()
+		atomic.StoreInt32(&cleared, 1)
+	}()
+	var total int
+	for _, _, ok := m.Next(); ok; _, _, ok = m.Next() {
+		time.Sleep(10 * time.Millisecond)
+		total++
+	}
+	req.Equal(1, total)
+	req.Zero(atomic.LoadInt32(&set))
+	req.Zero(atomic.LoadInt32(&got))
+	req.Zero(atomic.LoadInt32(&cleared))
+	m.Unlock()
+	req.Eventually(func() bool {
+		return atomic.LoadInt32(&set)+atomic.LoadInt32(&got)+atomic.LoadInt32(&cleared) == 3
+	}, time.Second, time.Millisecond)
+}
+
+func TestClear(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8), CountersOption())
+	m.Clear()
+	req.Zero(m.Len())
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	req.EqualValues(7, m.directory().B)
+	m.Clear()
+	req.Zero(m.Len())
+	req.Zero(m.directory().B)
+	_, ok := m.Get(1)
+	req.False(ok)
+	req.Empty(m.ToMap())
+	m.Set(1, 1)
+	req.Equal(1, m.Len())
+	req.Equal(map[int]int{1: 1}, m.ToMap())
+	req.NotZero(m.Counters())
+	m.Reset()
+	req.Zero(m.Len())
+	req.Equal(Counters{}, m.Counters())
+
+	// writes racing with Clear are either kept or dropped, and counted in
+	// the buckets they land in
+	var (
+		wg   sync.WaitGroup
+		stop atomic.Bool
+	)
+	wg.Add(4)
+	for n := 0; n < 4; n++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; !stop.Load(); i++ {
+				k := n*1000 + i%1000
+				if i%3 == 0 {
+					m.Del(k)
+				} else {
+					m.Set(k, i)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		m.Clear()
+		runtime.Gosched()
+	}
+	stop.Store(true)
+	wg.Wait()
+	req.Equal(len(m.ToMap()), m.Len())
+	req.Equal(m.Len(), m.Stats().Count)
 }
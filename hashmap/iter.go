@@ -0,0 +1,185 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"iter"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Iterator walks the fence-linked list of a map without locking it.
+	//
+	// It is weakly consistent like sync.Map.Range: each key is returned at
+	// most once, and entries set or deleted during the walk may or may not
+	// be seen
+	Iterator[K comparable, V any] struct {
+		curr   *hashNode
+		lo, hi uint64 // hash range [lo, hi) of a partition, hi = 0 means no upper bound
+	}
+)
+
+// Iter returns a new iterator positioned before the first entry of the map
+func (h *Map[K, V]) Iter() *Iterator[K, V] {
+	return &Iterator[K, V]{
+		curr: &h.getBucket(0).fence,
+	}
+}
+
+// Next returns next <k, v> in the map, and false once the map is exhausted
+func (it *Iterator[K, V]) Next() (K, V, bool) {
+	// a deleted node keeps its link to the marker and then the rest of the
+	// list, so the walk always moves forward, and ends at the last fence which
+	// links to nothing
+	for next := it.curr.next(); next != nil; next = next.next() {
+		if it.hi != 0 && next.hash >= it.hi {
+			// the rest belongs to the next partition
+			break
+		}
+		it.curr = next
+		if isFence(next) || isMarker(next) || next.hash < it.lo {
+			continue
+		}
+		if e := (*entry[V])(next.value()); e != nil && !e.expired() {
+			return *(*K)(next.key), e.val, true
+		}
+	}
+	var (
+		k K
+		v V
+	)
+	return k, v, false
+}
+
+// Partitions returns n iterators over disjoint hash ranges that cover the whole
+// map, to walk the map in parallel. Each iterator starts at the bucket of its
+// range, and is consistent like Iterator
+func (h *Map[K, V]) Partitions(n int) []*Iterator[K, V] {
+	if n < 1 {
+		n = 1
+	}
+	d := h.directory()
+	its := make([]*Iterator[K, V], n)
+	for i := range its {
+		// [i, i+1) * 2^64 / n
+		lo, _ := bits.Div64(uint64(i), 0, uint64(n))
+		var hi uint64
+		if i+1 < n {
+			hi, _ = bits.Div64(uint64(i+1), 0, uint64(n))
+		}
+		its[i] = &Iterator[K, V]{
+			curr: &h.bucketAt(d, lo>>(64-d.B)).fence,
+			lo:   lo,
+			hi:   hi,
+		}
+	}
+	return its
+}
+
+// ParallelRange calls f for each key and value in the map like Range, from
+// the given number of go-routines, each walking a partition of the map. f is
+// called concurrently, and all go-routines stop once f returns false
+func (h *Map[K, V]) ParallelRange(workers int, f func(key K, value V) bool) {
+	var (
+		stop uint32
+		wg   sync.WaitGroup
+	)
+	for _, it := range h.Partitions(workers) {
+		wg.Add(1)
+		go func(it *Iterator[K, V]) {
+			defer wg.Done()
+			for k, v, ok := it.Next(); ok && atomic.LoadUint32(&stop) == 0; k, v, ok = it.Next() {
+				if !f(k, v) {
+					atomic.StoreUint32(&stop, 1)
+				}
+			}
+		}(it)
+	}
+	wg.Wait()
+}
+
+// Range calls f sequentially for each key and value in the map, and stops if f
+// returns false. Range does not lock the map, see Iterator for its consistency
+func (h *Map[K, V]) Range(f func(key K, value V) bool) {
+	it := h.Iter()
+	for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// All returns an iterator over <k, v> in the map, to be used as
+// for k, v := range m.All()
+func (h *Map[K, V]) All() iter.Seq2[K, V] {
+	return h.Range
+}
+
+// Keys returns an iterator over keys in the map
+func (h *Map[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		h.Range(func(k K, _ V) bool {
+			return yield(k)
+		})
+	}
+}
+
+// Values returns an iterator over values in the map
+func (h *Map[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		h.Range(func(_ K, v V) bool {
+			return yield(v)
+		})
+	}
+}
+
+// SnapshotKeys returns the keys in the map at a single point in time, unlike
+// Keys which walks the map while it changes
+func (h *Map[K, V]) SnapshotKeys() []K {
+	items := h.snapshot()
+	keys := make([]K, len(items))
+	for i, it := range items {
+		keys[i] = it.key
+	}
+	return keys
+}
+
+// SnapshotValues returns the values in the map at a single point in time,
+// unlike Values which walks the map while it changes
+func (h *Map[K, V]) SnapshotValues() []V {
+	items := h.snapshot()
+	values := make([]V, len(items))
+	for i, it := range items {
+		values[i] = it.e.val
+	}
+	return values
+}
+
+// ToMap copies the map at a single point in time into a native map. A []byte
+// key, which is not hashable by a native map, is copied as a string
+func (h *Map[K, V]) ToMap() map[K]V {
+	items := h.snapshot()
+	m := make(map[K]V, len(items))
+	for _, it := range items {
+		k := it.key
+		if b, ok := any(k).([]byte); ok {
+			k, _ = any(string(b)).(K)
+		}
+		m[k] = it.e.val
+	}
+	return m
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterator(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	it := m.Iter()
+	k, v, ok := it.Next()
+	req.False(ok)
+	req.Zero(k)
+	req.Zero(v)
+
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i*i)
+	}
+
+	// 2 iterators walk the map independently
+	it, it1 := m.Iter(), m.Iter()
+	seen := make(map[int]bool)
+	for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+		req.Equal(k*k, v)
+		req.False(seen[k])
+		seen[k] = true
+		k1, v1, ok1 := it1.Next()
+		req.True(ok1)
+		req.Equal(k, k1)
+		req.Equal(v, v1)
+	}
+	req.Equal(10000, len(seen))
+	_, _, ok = it1.Next()
+	req.False(ok)
+	_, _, ok = it.Next()
+	req.False(ok)
+}
+
+func TestIteratorConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+
+	// keys [0, 5000) stay in the map, keys [5000, 10000) are deleted and keys
+	// [10000, 20000) are added, while 4 iterators are running
+	var (
+		wg    sync.WaitGroup
+		stay  = make([]int, 4)
+		dupes = make([]int, 4)
+	)
+	wg.Add(6)
+	go func() {
+		for i := 5000; i < 10000; i++ {
+			m.Del(i)
+		}
+		wg.Done()
+	}()
+	go func() {
+		for i := 10000; i < 20000; i++ {
+			m.Set(i, i)
+		}
+		wg.Done()
+	}()
+	for n := 0; n < 4; n++ {
+		go func(n int) {
+			seen := make(map[int]bool)
+			it := m.Iter()
+			for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+				if k != v {
+					panic("value not match")
+				}
+				if seen[k] {
+					dupes[n]++
+				}
+				seen[k] = true
+				if k < 5000 {
+					stay[n]++
+				}
+			}
+			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+	for n := 0; n < 4; n++ {
+		req.Equal(5000, stay[n])
+		req.Zero(dupes[n])
+	}
+	req.Equal(15000, m.Len())
+}
+
+func TestPartitions(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	for _, it := range m.Partitions(4) {
+		_, _, ok := it.Next()
+		req.False(ok)
+	}
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i*i)
+	}
+	for _, n := range []int{0, 1, 3, 16, 1000, 100000} {
+		its := m.Partitions(n)
+		req.Len(its, max(n, 1))
+		seen := make(map[int]bool)
+		for _, it := range its {
+			for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+				req.Equal(k*k, v)
+				req.False(seen[k])
+				seen[k] = true
+			}
+			_, _, ok := it.Next()
+			req.False(ok)
+		}
+		req.Equal(10000, len(seen))
+	}
+
+	// keys [0, 5000) stay in the map while others are deleted and added
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		for i := 5000; i < 10000; i++ {
+			m.Del(i)
+		}
+		wg.Done()
+	}()
+	go func() {
+		for i := 10000; i < 20000; i++ {
+			m.Set(i, i*i)
+		}
+		wg.Done()
+	}()
+	var (
+		stay, dupes int
+		mutex       sync.Mutex
+		seen        = make(map[int]bool)
+	)
+	m.ParallelRange(4, func(k, v int) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if seen[k] {
+			dupes++
+		}
+		seen[k] = true
+		if k < 5000 {
+			stay++
+		}
+		return true
+	})
+	wg.Wait()
+	req.Equal(5000, stay)
+	req.Zero(dupes)
+
+	// stop early
+	var calls int64
+	m.ParallelRange(4, func(k, v int) bool {
+		atomic.AddInt64(&calls, 1)
+		return false
+	})
+	req.True(calls >= 1 && calls <= 4)
+}
+
+func TestRange(t *testing.T) {
+	req := require.New(t)
+
+	m := New()
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i*i)
+	}
+
+	var total int
+	m.Range(func(k, v interface{}) bool {
+		req.Equal(k.(int)*k.(int), v)
+		total++
+		return true
+	})
+	req.Equal(1000, total)
+
+	// stop early
+	total = 0
+	m.Range(func(k, v interface{}) bool {
+		total++
+		return total < 10
+	})
+	req.Equal(10, total)
+
+	// range-over-func
+	total = 0
+	for k, v := range m.All() {
+		req.Equal(k.(int)*k.(int), v)
+		if total++; total == 10 {
+			break
+		}
+	}
+	req.Equal(10, total)
+
+	var sum, sqSum int
+	for k := range m.Keys() {
+		sum += k.(int)
+	}
+	for v := range m.Values() {
+		sqSum += v.(int)
+	}
+	req.Equal(999*1000/2, sum)
+	req.Equal(999*1000*1999/6, sqSum)
+
+	// panic in the loop body does not leave the map locked
+	req.Panics(func() {
+		for range m.All() {
+			panic("abort")
+		}
+	})
+	m.Set(1000, 1000*1000)
+	m.Lock()
+	m.Unlock()
+	req.Equal(1001, m.Len())
+}
+
+func TestSnapshotKeys(t *testing.T) {
+	req := require.New(t)
+
+	m := New()
+	req.Empty(m.SnapshotKeys())
+	req.Empty(m.SnapshotValues())
+	req.Empty(m.ToMap())
+	m.Set(1, "a")
+	m.Set("b", 2)
+	m.Set([]byte("c"), 3)
+	req.ElementsMatch([]interface{}{1, "b", []byte("c")}, m.SnapshotKeys())
+	req.ElementsMatch([]interface{}{"a", 2, 3}, m.SnapshotValues())
+	req.Equal(map[interface{}]interface{}{1: "a", "b": 2, "c": 3}, m.ToMap())
+}
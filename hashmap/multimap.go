@@ -0,0 +1,108 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"iter"
+	"slices"
+)
+
+type (
+	// MultiMap is a concurrent map from a key to a set of values. The values
+	// of a key are a slice that is never modified, a change stores a new
+	// slice like Compute, so keys are updated with CAS independently
+	MultiMap[K comparable, V comparable] struct {
+		m *Map[K, []V]
+	}
+)
+
+// NewMultiMap creates a new multimap, which takes the options of NewMap
+func NewMultiMap[K comparable, V comparable](opts ...Option) *MultiMap[K, V] {
+	return &MultiMap[K, V]{
+		m: NewMap[K, []V](opts...),
+	}
+}
+
+// Len returns the number of keys
+func (mm *MultiMap[K, V]) Len() int {
+	return mm.m.Len()
+}
+
+// Put adds the value to the key, and returns true if it is newly added
+func (mm *MultiMap[K, V]) Put(key K, value V) bool {
+	if mm.ContainsEntry(key, value) {
+		return false
+	}
+	var added bool
+	mm.m.compute(key, func(old []V, _ bool) ([]V, computeOp) {
+		if added = !slices.Contains(old, value); !added {
+			// added meanwhile, no need to write
+			return old, computeLeave
+		}
+		// copy on write, the old slice may be read meanwhile
+		return append(old[:len(old):len(old)], value), computeStore
+	})
+	return added
+}
+
+// Remove removes the value from the key, and returns true if it was there.
+// The key is deleted once its last value is removed
+func (mm *MultiMap[K, V]) Remove(key K, value V) bool {
+	if !mm.ContainsEntry(key, value) {
+		return false
+	}
+	var removed bool
+	mm.m.compute(key, func(old []V, _ bool) ([]V, computeOp) {
+		i := slices.Index(old, value)
+		if removed = i >= 0; !removed {
+			return old, computeLeave
+		}
+		if len(old) == 1 {
+			return nil, computeDelete
+		}
+		return slices.Delete(slices.Clone(old), i, i+1), computeStore
+	})
+	return removed
+}
+
+// RemoveAll deletes the key, and returns its values
+func (mm *MultiMap[K, V]) RemoveAll(key K) []V {
+	values, _ := mm.m.LoadAndDelete(key)
+	return values
+}
+
+// Get returns a copy of the values of the key, in the order they are added
+func (mm *MultiMap[K, V]) Get(key K) []V {
+	values, _ := mm.m.Get(key)
+	return slices.Clone(values)
+}
+
+// ContainsEntry returns true if the value is one of the values of the key
+func (mm *MultiMap[K, V]) ContainsEntry(key K, value V) bool {
+	values, _ := mm.m.Get(key)
+	return slices.Contains(values, value)
+}
+
+// All returns an iterator over each key and a copy of its values, consistent
+// like Map.Range
+func (mm *MultiMap[K, V]) All() iter.Seq2[K, []V] {
+	return func(yield func(K, []V) bool) {
+		for k, values := range mm.m.All() {
+			if !yield(k, slices.Clone(values)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiMap(t *testing.T) {
+	req := require.New(t)
+
+	mm := NewMultiMap[string, int]()
+	req.Empty(mm.Get("a"))
+	req.True(mm.Put("a", 1))
+	req.True(mm.Put("a", 2))
+	req.False(mm.Put("a", 1))
+	req.True(mm.Put("b", 1))
+	req.Equal(2, mm.Len())
+	req.Equal([]int{1, 2}, mm.Get("a"))
+	req.True(mm.ContainsEntry("a", 2))
+	req.False(mm.ContainsEntry("b", 2))
+	req.False(mm.ContainsEntry("c", 1))
+
+	// Get returns a copy
+	values := mm.Get("a")
+	values[0] = 100
+	req.Equal([]int{1, 2}, mm.Get("a"))
+
+	req.True(mm.Remove("a", 1))
+	req.False(mm.Remove("a", 1))
+	req.False(mm.Remove("c", 1))
+	req.Equal([]int{2}, mm.Get("a"))
+	// the key is deleted with its last value
+	req.True(mm.Remove("a", 2))
+	req.Equal(1, mm.Len())
+	req.Nil(mm.Get("a"))
+
+	req.True(mm.Put("b", 2))
+	req.Equal([]int{1, 2}, mm.RemoveAll("b"))
+	req.Nil(mm.RemoveAll("b"))
+	req.Zero(mm.Len())
+
+	mm.Put("x", 1)
+	mm.Put("y", 2)
+	for k, values := range mm.All() {
+		req.Len(values, 1)
+		values[0] = 0
+		req.True(mm.ContainsEntry(k, map[string]int{"x": 1, "y": 2}[k]))
+	}
+}
+
+func TestMultiMapConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	// 8 go-routines add values to the same 10 keys, then remove half of them
+	mm := NewMultiMap[int, int]()
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func(n int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				for k := 0; k < 10; k++ {
+					if !mm.Put(k, n*100+i) {
+						t.Errorf("%d of key %d not added", n*100+i, k)
+					}
+				}
+			}
+			for i := 0; i < 100; i += 2 {
+				for k := 0; k < 10; k++ {
+					if !mm.Remove(k, n*100+i) {
+						t.Errorf("%d of key %d not removed", n*100+i, k)
+					}
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	req.Equal(10, mm.Len())
+	for k := 0; k < 10; k++ {
+		values := mm.Get(k)
+		req.Len(values, 400)
+		slices.Sort(values)
+		for i, v := range values {
+			req.Equal(2*i+1, v)
+		}
+	}
+}
+
+func TestMultiMapNoWrite(t *testing.T) {
+	req := require.New(t)
+
+	mm := NewMultiMap[string, int]()
+	w := mm.m.Watch("a")
+	defer w.Close()
+	req.True(mm.Put("a", 1))
+	req.Equal(Event[string, []int]{EventSet, "a", []int{1}}, <-w.C)
+
+	// a value already there, or one to remove that is not, writes nothing
+	req.False(mm.Put("a", 1))
+	req.False(mm.Remove("a", 2))
+	v, ok := mm.m.compute("a", func(old []int, present bool) ([]int, computeOp) {
+		req.True(present)
+		return nil, computeLeave
+	})
+	req.True(ok)
+	req.Equal([]int{1}, v)
+	req.Empty(w.C)
+	req.Equal([]int{1}, mm.Get("a"))
+}
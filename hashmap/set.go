@@ -0,0 +1,149 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"iter"
+)
+
+type (
+	// Set is a concurrent set of keys. It is a Map of struct{} values, whose
+	// keys all share a single entry unless the set has a default ttl, so a key
+	// costs only its node. Add stores nothing for a present key
+	Set[K comparable] struct {
+		m    *Map[K, struct{}]
+		opts []Option // for the sets made by Union, Intersect and Difference
+	}
+)
+
+// present is the entry shared by the keys of all sets. It is never written:
+// it never expires, and its stamp stays at epoch 0 as a Set takes no snapshot
+var present = &entry[struct{}]{}
+
+// NewSet creates a new set, which takes the options of NewMap
+func NewSet[K comparable](opts ...Option) *Set[K] {
+	return &Set[K]{
+		m:    NewMap[K, struct{}](opts...),
+		opts: opts,
+	}
+}
+
+func (s *Set[K]) Len() int {
+	return s.m.Len()
+}
+
+// Add adds the key, and returns true if the key is newly added
+func (s *Set[K]) Add(key K) bool {
+	if s.Contains(key) {
+		return false
+	}
+	_, loaded := s.m.loadOrStore(key, s.entry(0))
+	return !loaded
+}
+
+// entry returns the entry of a new key, a new one only if it expires
+func (s *Set[K]) entry(int) *entry[struct{}] {
+	if s.m.ttl > 0 {
+		return s.m.newEntry(struct{}{}, s.m.ttl)
+	}
+	return present
+}
+
+// Contains returns true if the key is in the set
+func (s *Set[K]) Contains(key K) bool {
+	_, ok := s.m.Get(key)
+	return ok
+}
+
+// Remove removes the key, and returns true if the key was in the set
+func (s *Set[K]) Remove(key K) bool {
+	_, ok := s.m.LoadAndDelete(key)
+	return ok
+}
+
+// Clear removes all keys at once, like Map.Clear
+func (s *Set[K]) Clear() {
+	s.m.Clear()
+}
+
+// All returns an iterator over the keys, consistent like Map.Range
+func (s *Set[K]) All() iter.Seq[K] {
+	return s.m.Keys()
+}
+
+// Range calls f sequentially for each key, and stops if f returns false
+func (s *Set[K]) Range(f func(key K) bool) {
+	s.m.Range(func(k K, _ struct{}) bool {
+		return f(k)
+	})
+}
+
+// Union returns a new set of the keys in s or other. Like all set operations,
+// it walks the sets while they may change, see Map.Range
+func (s *Set[K]) Union(other *Set[K]) *Set[K] {
+	u := NewSet[K](s.opts...)
+	u.addAll(s.keys())
+	u.addAll(other.keys())
+	return u
+}
+
+// Intersect returns a new set of the keys in both s and other
+func (s *Set[K]) Intersect(other *Set[K]) *Set[K] {
+	return s.filter(other, true)
+}
+
+// Difference returns a new set of the keys in s but not in other
+func (s *Set[K]) Difference(other *Set[K]) *Set[K] {
+	return s.filter(other, false)
+}
+
+// IsSubset returns true if every key in s is in other
+func (s *Set[K]) IsSubset(other *Set[K]) bool {
+	for k := range s.All() {
+		if !other.Contains(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns a new set of the keys in s, which are in other or not
+func (s *Set[K]) filter(other *Set[K], in bool) *Set[K] {
+	keys := s.keys()
+	_, found := other.m.GetMany(keys)
+	var n int
+	for i := range keys {
+		if found[i] == in {
+			keys[n] = keys[i]
+			n++
+		}
+	}
+	f := NewSet[K](s.opts...)
+	f.addAll(keys[:n])
+	return f
+}
+
+func (s *Set[K]) keys() []K {
+	keys := make([]K, 0, s.Len())
+	for k := range s.All() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// addAll adds the keys in a batch, see SetMany
+func (s *Set[K]) addAll(keys []K) {
+	s.m.storeMany(keys, s.entry)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *testing.T) {
+	req := require.New(t)
+
+	s := NewSet[int](BucketSizeOption(8))
+	for i := 0; i < 1000; i++ {
+		req.True(s.Add(i))
+	}
+	req.False(s.Add(0))
+	req.Equal(1000, s.Len())
+	req.True(s.Contains(999))
+	req.False(s.Contains(1000))
+	req.True(s.Remove(999))
+	req.False(s.Remove(999))
+	req.Equal(999, s.Len())
+
+	keys := slices.Sorted(s.All())
+	req.Len(keys, 999)
+	req.Equal(0, keys[0])
+	req.Equal(998, keys[998])
+	var n int
+	s.Range(func(int) bool {
+		n++
+		return n < 10
+	})
+	req.Equal(10, n)
+
+	// [0, 999) and [500, 1500)
+	other := NewSet[int]()
+	for i := 500; i < 1500; i++ {
+		other.Add(i)
+	}
+	sorted := func(s *Set[int]) []int {
+		return slices.Sorted(s.All())
+	}
+	span := func(from, to int) []int {
+		var keys []int
+		for i := from; i < to; i++ {
+			keys = append(keys, i)
+		}
+		return keys
+	}
+	req.Equal(span(0, 1500), sorted(s.Union(other)))
+	req.Equal(span(500, 999), sorted(s.Intersect(other)))
+	req.Equal(span(0, 500), sorted(s.Difference(other)))
+	req.Equal(span(999, 1500), sorted(other.Difference(s)))
+	req.False(s.IsSubset(other))
+	req.True(s.Intersect(other).IsSubset(other))
+	req.True(NewSet[int]().IsSubset(s))
+	// the operands are unchanged
+	req.Equal(999, s.Len())
+	req.Equal(1000, other.Len())
+
+	s.Clear()
+	req.Zero(s.Len())
+	req.Empty(sorted(s.Union(NewSet[int]())))
+}
+
+func TestSetSharedEntry(t *testing.T) {
+	req := require.New(t)
+
+	allocs := func(add func(int)) float64 {
+		i := 0
+		return testing.AllocsPerRun(1000, func() {
+			add(i)
+			i++
+		})
+	}
+	m := NewMap[int, struct{}](CapacityOption(1 << 12))
+	mapAllocs := allocs(func(k int) {
+		// as Add does
+		if _, ok := m.Get(k); !ok {
+			m.LoadOrStore(k, struct{}{})
+		}
+	})
+	s := NewSet[int](CapacityOption(1 << 12))
+	req.Equal(mapAllocs-1, allocs(func(k int) { s.Add(k) }))
+
+	// with a ttl each key has its own entry
+	s = NewSet[int](CapacityOption(1<<12), DefaultTTLOption(time.Hour))
+	req.Equal(mapAllocs, allocs(func(k int) { s.Add(k) }))
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// A snapshot is the map as of the end of an epoch. Every write is stamped with
+// the epoch it runs in, and a snapshot starts a new epoch, waits for writes of
+// the old epoch to finish, then walks the map for the latest entry of each key
+// stamped with the old epoch.
+//
+// Writes never wait for a snapshot. While a snapshot is running, a write of
+// the new epoch links its entry to the entry it replaces, and a delete records
+// the entry it removes, so the walk still finds the entries of the old epoch
+
+type (
+	// snapshot is the state of a running snapshot
+	snapshot struct {
+		epoch   uint64         // entries stamped with this or an earlier epoch are in the snapshot
+		dir     *directory     // directory of the list to walk, which Clear may replace meanwhile
+		deleted unsafe.Pointer // *record of entries deleted in the new epoch
+	}
+
+	// record is an entry deleted while a snapshot is running
+	record struct {
+		key  unsafe.Pointer
+		val  unsafe.Pointer
+		next *record
+	}
+
+	// item is a key and its entry in a snapshot
+	item[K comparable, V any] struct {
+		key K
+		e   *entry[V]
+	}
+)
+
+// pin registers a write in the current epoch, and returns the epoch and the
+// running snapshot if any. The write must call unpin once done. It waits while
+// Lock is held
+func (h *Map[K, V]) pin() (uint64, *snapshot) {
+	for {
+		epoch := atomic.LoadUint64(&h.epoch)
+		atomic.AddUint64(&h.pins[epoch&1], 1)
+		if atomic.LoadUint32(&h.locked) != 0 {
+			// Lock waits for the pinned writes to be done
+			atomic.AddUint64(&h.pins[epoch&1], ^uint64(0))
+			h.gate()
+			continue
+		}
+		if atomic.LoadUint64(&h.epoch) == epoch {
+			return epoch, (*snapshot)(atomic.LoadPointer(&h.snap))
+		}
+		// a snapshot has started a new epoch meanwhile
+		atomic.AddUint64(&h.pins[epoch&1], ^uint64(0))
+	}
+}
+
+func (h *Map[K, V]) unpin(epoch uint64) {
+	atomic.AddUint64(&h.pins[epoch&1], ^uint64(0))
+}
+
+// storeNode stores the node into its bucket like bucket.store, with the entry
+// stamped with the current epoch, and counts the node if it is inserted. A
+// batch passes its cursor c to resume the search, or nil otherwise
+func (h *Map[K, V]) storeNode(node *hashNode, cond func(*entry[V]) bool, c *cursor) (*hashNode, *entry[V], bool) {
+	epoch, s := h.pin()
+
+	e := (*entry[V])(node.val)
+	if e.stamp != epoch {
+		// the entry shared by the keys of a Set is left unwritten, as a
+		// Set never starts a new epoch
+		e.stamp = epoch
+	}
+	if s != nil && epoch > s.epoch {
+		// keep the replaced entry for the snapshot
+		check := cond
+		cond = func(curr *entry[V]) bool {
+			if check != nil && !check(curr) {
+				return false
+			}
+			e.prev = curr
+			return true
+		}
+	}
+	d, from := h.directory(), (**hashNode)(nil)
+	if c != nil {
+		d, from = c.d, c.seek(node.hash)
+	}
+	curr, old, stored := h.bucketAt(d, node.hash>>(64-d.B)).store(node, cond, h.retries(), from)
+	h.unpin(epoch)
+	if stored {
+		if old == nil {
+			// counted in the list it is inserted into, which Clear may have
+			// replaced meanwhile
+			atomic.AddUint64(d.count, 1)
+		}
+		// notified once unpinned, so a blocking watcher holds up no snapshot
+		h.notifyStored(node.hash, (*K)(node.key), old, e)
+	}
+	return curr, old, stored
+}
+
+// removeNode deletes the node from its bucket like bucket.remove, and counts
+// the node out if it is deleted. A batch passes its cursor c like storeNode
+func (h *Map[K, V]) removeNode(node *hashNode, cond func(*entry[V]) bool, c *cursor) (*entry[V], bool) {
+	epoch, s := h.pin()
+
+	if s != nil && epoch > s.epoch {
+		// record the entry for the snapshot before it is gone
+		check := cond
+		cond = func(curr *entry[V]) bool {
+			if check != nil && !check(curr) {
+				return false
+			}
+			s.record(node.key, unsafe.Pointer(curr))
+			return true
+		}
+	}
+	d, from := h.directory(), (**hashNode)(nil)
+	if c != nil {
+		d, from = c.d, c.seek(node.hash)
+	}
+	e, deleted := h.bucketAt(d, node.hash>>(64-d.B)).remove(node, cond, from)
+	h.unpin(epoch)
+	if deleted {
+		atomic.AddUint64(d.count, ^uint64(0))
+		h.notifyRemoved(node.hash, (*K)(node.key), e)
+	}
+	return e, deleted
+}
+
+func (s *snapshot) record(key, val unsafe.Pointer) {
+	r := record{key: key, val: val}
+	for {
+		r.next = (*record)(atomic.LoadPointer(&s.deleted))
+		if atomic.CompareAndSwapPointer(&s.deleted, unsafe.Pointer(r.next), unsafe.Pointer(&r)) {
+			return
+		}
+	}
+}
+
+// snapshot returns the unexpired entries of the map as of a single point in
+// time. Only one snapshot runs at a time, writes keep running meanwhile. A zero
+// Map has no entries, so it encodes like an empty one
+func (h *Map[K, V]) snapshot() []item[K, V] {
+	if atomic.LoadPointer(&h.dir) == nil {
+		return nil
+	}
+	h.snapLock.Lock()
+	defer h.snapLock.Unlock()
+	return h.collect(h.startSnapshot())
+}
+
+// startSnapshot starts a new epoch, and waits for writes of the old epoch
+func (h *Map[K, V]) startSnapshot() *snapshot {
+	s := snapshot{
+		epoch: atomic.LoadUint64(&h.epoch),
+		dir:   h.directory(),
+	}
+	atomic.StorePointer(&h.snap, unsafe.Pointer(&s))
+	atomic.AddUint64(&h.epoch, 1)
+	for atomic.LoadUint64(&h.pins[s.epoch&1]) != 0 {
+		runtime.Gosched()
+	}
+	return &s
+}
+
+// collect walks the map for the entries of the snapshot, and ends it
+func (h *Map[K, V]) collect(s *snapshot) []item[K, V] {
+	var (
+		items []item[K, V]
+		seen  = make(map[*entry[V]]struct{})
+	)
+	add := func(key unsafe.Pointer, e *entry[V]) {
+		if e = e.at(s.epoch); e == nil {
+			return
+		}
+		if _, ok := seen[e]; ok {
+			return
+		}
+		seen[e] = struct{}{}
+		if !e.expired() {
+			items = append(items, item[K, V]{key: *(*K)(key), e: e})
+		}
+	}
+	for curr := h.bucketAt(s.dir, 0).fence.next(); !isTail(curr); curr = curr.next() {
+		if isFence(curr) || isMarker(curr) {
+			continue
+		}
+		if e := (*entry[V])(curr.value()); e != nil {
+			add(curr.key, e)
+		}
+	}
+	atomic.StorePointer(&h.snap, nil)
+
+	// a key deleted before the walk reaches it is only found in the records
+	for r := (*record)(atomic.LoadPointer(&s.deleted)); r != nil; r = r.next {
+		add(r.key, (*entry[V])(r.val))
+	}
+	return items
+}
+
+// at returns the latest version of the entry stamped with the epoch or an
+// earlier one, nil if there is none. The versions before it are dropped, no
+// later snapshot needs them
+func (e *entry[V]) at(epoch uint64) *entry[V] {
+	for ; e != nil; e = e.prev {
+		if e.stamp <= epoch {
+			e.prev = nil
+			return e
+		}
+	}
+	return nil
+}
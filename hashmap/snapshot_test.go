@@ -0,0 +1,132 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	req.Empty(m.snapshot())
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	items := m.snapshot()
+	req.Len(items, 100)
+	for _, it := range items {
+		req.Equal(it.key, it.e.val)
+	}
+
+	// writes after the snapshot starts are not seen
+	m.snapLock.Lock()
+	s := m.startSnapshot()
+	m.Set(1, 100)
+	m.Del(2)
+	m.Set(2, 200)
+	m.Del(3)
+	m.Set(200, 200)
+	m.Compute(4, func(old int, _ bool) (int, bool) {
+		return old + 100, true
+	})
+	items = m.collect(s)
+	m.snapLock.Unlock()
+	req.Len(items, 100)
+	for _, it := range items {
+		req.Equal(it.key, it.e.val)
+	}
+	v, _ := m.Get(1)
+	req.Equal(100, v)
+	_, ok := m.Get(3)
+	req.False(ok)
+	req.Len(m.snapshot(), 100)
+
+	// each writer sets its keys in order in even rounds, and deletes them in
+	// odd rounds. At a single point in time, its keys set in the latest round
+	// are followed by those of the round before
+	const (
+		writers = 4
+		keys    = 1000
+	)
+	m = NewMap[int, int](BucketSizeOption(8))
+	var (
+		wg     sync.WaitGroup
+		stop   atomic.Bool
+		rounds atomic.Int64
+	)
+	wg.Add(writers)
+	for w := 0; w < writers; w++ {
+		go func() {
+			defer wg.Done()
+			for round := 0; !stop.Load(); round++ {
+				for i := w * keys; i < (w+1)*keys; i++ {
+					if round%2 == 0 {
+						m.Set(i, round)
+					} else {
+						m.Del(i)
+					}
+				}
+				rounds.Add(1)
+			}
+		}()
+	}
+
+	for n := 0; n < 10; n++ {
+		// let the writers run a round between snapshots
+		for r := rounds.Load(); rounds.Load() < r+writers; {
+			runtime.Gosched()
+		}
+		values := make(map[int]int)
+		for _, it := range m.snapshot() {
+			values[it.key] = it.e.val
+		}
+		for w := 0; w < writers; w++ {
+			var (
+				round    = -1
+				switched int // times the keys switch between present and absent
+				present  bool
+			)
+			for i := w * keys; i < (w+1)*keys; i++ {
+				v, ok := values[i]
+				if ok {
+					if round == -1 {
+						round = v
+					}
+					req.Equal(round, v)
+				}
+				if i > w*keys && ok != present {
+					switched++
+				}
+				present = ok
+			}
+			req.LessOrEqual(switched, 1)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+
+	// versions kept for the snapshots are dropped by the next one
+	m.snapshot()
+	for _, it := range m.snapshot() {
+		req.Nil(it.e.prev)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Stats is a snapshot of the statistics of a map
+	Stats struct {
+		Count      int     // number of entries
+		Buckets    int     // number of buckets
+		MinBucket  int     // number of entries in the shortest bucket
+		MaxBucket  int     // number of entries in the longest bucket
+		MeanBucket float64 // average number of entries in a bucket
+		Histogram  []int   // Histogram[i] is the number of buckets with i entries
+		Counters
+	}
+
+	// Counters are the running totals of a map, which are cheap to read
+	Counters struct {
+		Gets       uint64        // number of Get calls, counted with CountersOption
+		Sets       uint64        // number of writes, counted with CountersOption
+		Dels       uint64        // number of deletes, counted with CountersOption
+		Expands    uint64        // number of times the buckets doubled
+		Shrinks    uint64        // number of times the buckets halved
+		CASRetries uint64        // number of failed CAS when storing an entry, counted with CountersOption
+		ResizeTime time.Duration // total time spent resizing the buckets
+	}
+
+	// stats are the counters of a map
+	stats struct {
+		gets       uint64
+		sets       uint64
+		dels       uint64
+		expands    uint64
+		shrinks    uint64
+		casRetries uint64
+		resizeTime int64 // in nanoseconds
+	}
+)
+
+// Stats returns the statistics of the map. It walks the whole map to measure
+// the buckets, so the cost is proportional to the size of the map, and entries
+// set or deleted meanwhile may or may not be counted
+func (h *Map[K, V]) Stats() Stats {
+	var (
+		d    = h.directory()
+		size = make([]int, len(d.buckets))
+	)
+	for curr := h.bucketAt(d, 0).fence.next(); !isTail(curr); curr = curr.next() {
+		if !isFence(curr) && !isMarker(curr) && curr.value() != nil {
+			size[curr.hash>>(64-d.B)]++
+		}
+	}
+
+	st := Stats{
+		Count:     int(atomic.LoadUint64(d.count)),
+		Buckets:   len(size),
+		MinBucket: size[0],
+		Counters:  h.Counters(),
+	}
+	var total int
+	for _, n := range size {
+		total += n
+		st.MinBucket = min(st.MinBucket, n)
+		st.MaxBucket = max(st.MaxBucket, n)
+	}
+	st.MeanBucket = float64(total) / float64(len(size))
+	st.Histogram = make([]int, st.MaxBucket+1)
+	for _, n := range size {
+		st.Histogram[n]++
+	}
+	return st
+}
+
+// Counters returns the running totals of the map. Unlike Stats, it does not
+// walk the map, so it can be polled by a metrics exporter
+func (h *Map[K, V]) Counters() Counters {
+	return Counters{
+		Gets:       atomic.LoadUint64(&h.gets),
+		Sets:       atomic.LoadUint64(&h.sets),
+		Dels:       atomic.LoadUint64(&h.dels),
+		Expands:    atomic.LoadUint64(&h.expands),
+		Shrinks:    atomic.LoadUint64(&h.shrinks),
+		CASRetries: atomic.LoadUint64(&h.casRetries),
+		ResizeTime: time.Duration(atomic.LoadInt64(&h.resizeTime)),
+	}
+}
+
+// resetCounters zeroes the counters
+func (h *Map[K, V]) resetCounters() {
+	for _, c := range []*uint64{&h.gets, &h.sets, &h.dels, &h.expands, &h.shrinks, &h.casRetries} {
+		atomic.StoreUint64(c, 0)
+	}
+	atomic.StoreInt64(&h.resizeTime, 0)
+}
+
+// tally adds 1 to an operation counter, if counters are enabled
+func (h *Map[K, V]) tally(counter *uint64) {
+	if h.counters {
+		atomic.AddUint64(counter, 1)
+	}
+}
+
+// retries returns the counter of CAS retries, nil unless counters are enabled
+func (h *Map[K, V]) retries() *uint64 {
+	if h.counters {
+		return &h.casRetries
+	}
+	return nil
+}
+
+// resized counts a resize that started at the given time
+func (h *Map[K, V]) resized(start time.Time, counter *uint64) {
+	atomic.AddUint64(counter, 1)
+	atomic.AddInt64(&h.resizeTime, int64(time.Since(start)))
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](BucketSizeOption(8))
+	st := m.Stats()
+	req.Equal(Stats{
+		Buckets:   1,
+		Histogram: []int{1},
+	}, st)
+
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	st = m.Stats()
+	req.Equal(10000, st.Count)
+	req.Equal(1<<11, st.Buckets)
+	req.EqualValues(11, st.Expands)
+	req.Zero(st.Shrinks)
+	req.True(st.ResizeTime > 0)
+	req.InDelta(10000.0/2048, st.MeanBucket, 0.001)
+	req.LessOrEqual(st.MinBucket, 4)
+	req.GreaterOrEqual(st.MaxBucket, 5)
+	req.Len(st.Histogram, st.MaxBucket+1)
+	var buckets, count int
+	for n, b := range st.Histogram {
+		buckets += b
+		count += n * b
+	}
+	req.Equal(st.Buckets, buckets)
+	req.Equal(st.Count, count)
+
+	for i := 0; i < 10000; i++ {
+		m.Del(i)
+	}
+	st = m.Stats()
+	req.Zero(st.Count)
+	req.Equal(16, st.Buckets)
+	req.EqualValues(7, st.Shrinks)
+	req.Equal([]int{16}, st.Histogram)
+
+	// concurrent writers of the same keys may fail some CAS, which are retried
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func() {
+			for i := 0; i < 10000; i++ {
+				m.Set(i%100, i)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	req.Equal(100, m.Stats().Count)
+}
+
+func TestCounters(t *testing.T) {
+	req := require.New(t)
+
+	// writes racing on the same keys retry CAS, but nothing is counted
+	// without the option
+	contend := func(m *Map[int, int]) {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20000; j++ {
+					m.Set(j%16, j)
+					m.Del(j % 16)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	m := NewMap[int, int](CountersOption())
+	for i := 0; i < 100 && m.Counters().CASRetries == 0; i++ {
+		contend(m)
+	}
+	req.True(m.Counters().CASRetries > 0)
+	m = NewMap[int, int]()
+	contend(m)
+	c := m.Counters()
+	req.Equal(Counters{Expands: c.Expands, Shrinks: c.Shrinks, ResizeTime: c.ResizeTime}, c)
+
+	m = NewMap[int, int](CountersOption())
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	for i := 0; i < 2000; i++ {
+		m.Get(i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		m.Del(i)
+	}
+	m.Compute(1, func(old int, _ bool) (int, bool) {
+		return old + 1, true
+	})
+	m.Compute(3, func(int, bool) (int, bool) {
+		return 0, false
+	})
+	c = m.Counters()
+	req.EqualValues(2000, c.Gets)
+	req.EqualValues(1001, c.Sets)
+	req.EqualValues(501, c.Dels)
+	req.EqualValues(6, c.Expands)
+	req.Equal(c, m.Stats().Counters)
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTTLOption sets the ttl of entries written without an explicit ttl
+func DefaultTTLOption(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// ExpireIntervalOption sets how often expired entries are removed
+func ExpireIntervalOption(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.interval = interval
+		}
+	}
+}
+
+// OnExpireOption sets the callback which is called once for each expired entry,
+// when it is removed from the map or overwritten
+func OnExpireOption(f func(key, value interface{})) Option {
+	return func(o *options) {
+		o.onExpire = f
+	}
+}
+
+// SetWithTTL sets the value for a key, which expires after ttl. The entry never
+// expires if ttl <= 0
+func (h *Map[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	h.swap(key, h.newEntry(value, ttl))
+}
+
+func (h *Map[K, V]) newEntry(value V, ttl time.Duration) *entry[V] {
+	e := entry[V]{val: value}
+	if ttl > 0 {
+		e.expire = time.Now().Add(ttl).UnixNano()
+		h.startJanitor()
+	}
+	return &e
+}
+
+func (h *Map[K, V]) expired(key *K, e *entry[V]) {
+	if h.onExpire != nil {
+		h.onExpire(*key, e.val)
+	}
+}
+
+// startJanitor makes sure the janitor is running to remove expired entries
+func (h *Map[K, V]) startJanitor() {
+	atomic.StoreUint32(&h.ttlSet, 1)
+	if atomic.CompareAndSwapUint32(&h.running, 0, 1) {
+		go h.janitor()
+	}
+}
+
+// janitor periodically removes expired entries. It quits once no entry with a
+// ttl is left, so it does not keep an idle map from being garbage collected
+func (h *Map[K, V]) janitor() {
+	for {
+		time.Sleep(h.interval)
+		atomic.StoreUint32(&h.ttlSet, 0)
+		if h.sweep() > 0 {
+			continue
+		}
+		atomic.StoreUint32(&h.running, 0)
+		// an entry with ttl may have been set during the sweep, keep running
+		// unless another janitor has taken over
+		if atomic.LoadUint32(&h.ttlSet) == 0 || !atomic.CompareAndSwapUint32(&h.running, 0, 1) {
+			return
+		}
+	}
+}
+
+// sweep removes expired entries, and returns the number of entries that are
+// yet to expire
+func (h *Map[K, V]) sweep() int {
+	curr := &h.getBucket(0).fence
+
+	var pending int
+	for curr = curr.next(); curr != nil; curr = curr.next() {
+		if isFence(curr) || isMarker(curr) {
+			continue
+		}
+		e := (*entry[V])(curr.value())
+		if e == nil || e.expire == 0 {
+			continue
+		}
+		if !e.expired() {
+			pending++
+			continue
+		}
+		h.purge(curr, e)
+	}
+	return pending
+}
+
+// purge removes the expired entry e of a node, unless it is overwritten
+func (h *Map[K, V]) purge(curr *hashNode, e *entry[V]) {
+	node := hashNode{
+		hash: curr.hash,
+		key:  curr.key,
+	}
+	_, deleted := h.removeNode(&node, func(curr *entry[V]) bool {
+		return curr == e
+	}, nil)
+	if deleted {
+		h.removed((*K)(node.key), e)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTL(t *testing.T) {
+	req := require.New(t)
+
+	var (
+		mu      sync.Mutex
+		expired = make(map[interface{}]interface{})
+	)
+	m := New(
+		ExpireIntervalOption(10*time.Millisecond),
+		OnExpireOption(func(k, v interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, ok := expired[k]
+			req.False(ok, "expire callback is called twice")
+			expired[k] = v
+		}),
+	)
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(i, i*i, 50*time.Millisecond)
+	}
+	m.Set("stay", 0)
+	req.Equal(101, m.Len())
+	v, ok := m.Get(10)
+	req.True(ok)
+	req.Equal(100, v)
+
+	// wait for expiry, entries are invisible at once
+	time.Sleep(60 * time.Millisecond)
+	v, ok = m.Get(10)
+	req.False(ok)
+	req.Nil(v)
+	var total int
+	for k := range m.Keys() {
+		req.Equal("stay", k)
+		total++
+	}
+	req.Equal(1, total)
+
+	// janitor removes them, and quits afterwards
+	req.Eventually(func() bool {
+		return m.Len() == 1 && atomic.LoadUint32(&m.running) == 0
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	req.Equal(100, len(expired))
+	for i := 0; i < 100; i++ {
+		req.Equal(i*i, expired[i])
+	}
+	mu.Unlock()
+}
+
+func TestTTLOverwrite(t *testing.T) {
+	req := require.New(t)
+
+	var count int32
+	m := NewMap[string, int](
+		DefaultTTLOption(20*time.Millisecond),
+		ExpireIntervalOption(time.Hour),
+		OnExpireOption(func(k, v interface{}) {
+			atomic.AddInt32(&count, 1)
+		}),
+	)
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+	m.SetWithTTL("d", 4, 0)
+	time.Sleep(30 * time.Millisecond)
+
+	// expired entries are treated as absent, and replaced in place
+	v, loaded := m.LoadOrStore("a", 11)
+	req.False(loaded)
+	req.Equal(11, v)
+	v, loaded = m.Swap("b", 22)
+	req.False(loaded)
+	req.Zero(v)
+	req.False(m.CompareAndSwap("c", 3, 33))
+	v, ok := m.Compute("c", func(old int, present bool) (int, bool) {
+		req.False(present)
+		return 33, true
+	})
+	req.True(ok)
+	req.Equal(33, v)
+	v, ok = m.Get("d")
+	req.True(ok)
+	req.Equal(4, v)
+	req.Equal(4, m.Len())
+	req.EqualValues(3, atomic.LoadInt32(&count))
+
+	// expired entry is not reported by delete
+	time.Sleep(30 * time.Millisecond)
+	v, loaded = m.LoadAndDelete("a")
+	req.False(loaded)
+	req.Zero(v)
+	req.Equal(3, m.Len())
+	req.EqualValues(4, atomic.LoadInt32(&count))
+}
+
+func TestTTLLen(t *testing.T) {
+	req := require.New(t)
+
+	var count int32
+	m := NewMap[int, int](
+		DefaultTTLOption(20*time.Millisecond),
+		ExpireIntervalOption(time.Hour),
+		OnExpireOption(func(k, v interface{}) {
+			atomic.AddInt32(&count, 1)
+		}),
+	)
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	m.SetWithTTL(10, 10, 0)
+	time.Sleep(30 * time.Millisecond)
+
+	// Len counts the expired entries until they are removed
+	req.Equal(11, m.Len())
+	req.Zero(atomic.LoadInt32(&count))
+
+	// an expired entry found by a read is removed without the janitor
+	_, ok := m.Get(0)
+	req.False(ok)
+	req.Equal(10, m.Len())
+	_, found := m.GetMany([]int{1, 2, 3, 10})
+	req.Equal([]bool{false, false, false, true}, found)
+	req.Equal(7, m.Len())
+	req.EqualValues(4, atomic.LoadInt32(&count))
+
+	// a sweep removes the rest
+	req.Zero(m.sweep())
+	req.Equal(1, m.Len())
+	req.EqualValues(10, atomic.LoadInt32(&count))
+
+	// the janitor brings Len down within an interval, with no read
+	m = NewMap[int, int](
+		DefaultTTLOption(20*time.Millisecond),
+		ExpireIntervalOption(10*time.Millisecond),
+	)
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	req.Equal(10, m.Len())
+	req.Eventually(func() bool {
+		return m.Len() == 0
+	}, time.Second, time.Millisecond)
+}
@@ -15,11 +15,13 @@
 package hashmap
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"reflect"
+	"sync"
 	"unsafe"
-
-	"github.com/dchest/siphash"
 )
 
 const (
@@ -27,53 +29,281 @@ const (
 	intSize = (32 << (^uint(0) >> 63)) >> 3
 )
 
+// hasherFor picks the hash function for key type K, so typed keys skip the
+// type switch in hashAny
+func hasherFor[K comparable]() func(hs Hasher, k0, k1 uint64, key K) uint64 {
+	t := reflect.TypeOf((*K)(nil)).Elem()
+	if t.Kind() == reflect.Interface || t.Implements(hash64Type) {
+		// interface keys, and types implementing Hash64, which may well be
+		// of a basic kind like type ID uint64
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return hashAny(hs, k0, k1, key)
+		}
+	}
+	switch t.Kind() {
+	case reflect.Uint8:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1+1, unsafe.Pointer(&key), 1)
+		}
+	case reflect.Int8:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1-1, unsafe.Pointer(&key), 1)
+		}
+	case reflect.Uint16:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1+1, unsafe.Pointer(&key), 2)
+		}
+	case reflect.Int16:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1-1, unsafe.Pointer(&key), 2)
+		}
+	case reflect.Uint32:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1+1, unsafe.Pointer(&key), 4)
+		}
+	case reflect.Int32:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1-1, unsafe.Pointer(&key), 4)
+		}
+	case reflect.Uint64:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return *(*uint64)(unsafe.Pointer(&key))
+		}
+	case reflect.Int64:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1-1, unsafe.Pointer(&key), 8)
+		}
+	case reflect.Uint:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1+2, unsafe.Pointer(&key), intSize)
+		}
+	case reflect.Int:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1-2, unsafe.Pointer(&key), intSize)
+		}
+	case reflect.String:
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return strhash(hs, k0-1, k1, *(*string)(unsafe.Pointer(&key)))
+		}
+	}
+
+	if size := int(t.Size()); isMemHashable(t) {
+		// the bytes of the key are exactly what == compares
+		return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+			return memhash(hs, k0, k1+3, unsafe.Pointer(&key), size)
+		}
+	}
+	return func(hs Hasher, k0, k1 uint64, key K) uint64 {
+		return hashValue(hs, k0, k1+3, reflect.NewAt(t, unsafe.Pointer(&key)).Elem())
+	}
+}
+
 // 64-bit hash provides 2^32 collision-resistance, which suffices for most use-case
-func (h *hmap) hash(key interface{}) uint64 {
+func hashAny(hs Hasher, k0, k1 uint64, key interface{}) uint64 {
 	switch v := key.(type) {
 	case uint8:
-		return memhash(h.k0, h.k1+1, unsafe.Pointer(&v), 1)
+		return memhash(hs, k0, k1+1, unsafe.Pointer(&v), 1)
 	case int8:
-		return memhash(h.k0, h.k1-1, unsafe.Pointer(&v), 1)
+		return memhash(hs, k0, k1-1, unsafe.Pointer(&v), 1)
 	case uint16:
-		return memhash(h.k0, h.k1+1, unsafe.Pointer(&v), 2)
+		return memhash(hs, k0, k1+1, unsafe.Pointer(&v), 2)
 	case int16:
-		return memhash(h.k0, h.k1-1, unsafe.Pointer(&v), 2)
+		return memhash(hs, k0, k1-1, unsafe.Pointer(&v), 2)
 	case uint32:
-		return memhash(h.k0, h.k1+1, unsafe.Pointer(&v), 4)
+		return memhash(hs, k0, k1+1, unsafe.Pointer(&v), 4)
 	case int32:
-		return memhash(h.k0, h.k1-1, unsafe.Pointer(&v), 4)
+		return memhash(hs, k0, k1-1, unsafe.Pointer(&v), 4)
 	case uint64:
 		return v
 	case int64:
-		return memhash(h.k0, h.k1-1, unsafe.Pointer(&v), 8)
+		return memhash(hs, k0, k1-1, unsafe.Pointer(&v), 8)
 	case uint:
-		return memhash(h.k0, h.k1+2, unsafe.Pointer(&v), intSize)
+		return memhash(hs, k0, k1+2, unsafe.Pointer(&v), intSize)
 	case int:
-		return memhash(h.k0, h.k1-2, unsafe.Pointer(&v), intSize)
+		return memhash(hs, k0, k1-2, unsafe.Pointer(&v), intSize)
 	case []byte:
-		return siphash.Hash(h.k0, h.k1, v)
+		return hs.Hash(k0, k1, v)
 	case string:
-		hdr := (*reflect.StringHeader)(unsafe.Pointer(&v))
-		sh := reflect.SliceHeader{
-			Data: hdr.Data,
-			Len:  hdr.Len,
-			Cap:  hdr.Len,
-		}
-		return siphash.Hash(h.k0-1, h.k1, *(*[]byte)(unsafe.Pointer(&sh)))
+		return strhash(hs, k0-1, k1, v)
 	default:
 		if h, ok := v.(Hash64); ok {
 			return h.Sum64()
 		}
-		panic(fmt.Errorf("unsupported key type %T", v))
+		return hashValue(hs, k0, k1+3, reflect.ValueOf(v))
+	}
+}
+
+// keyEqual reports whether 2 keys are equal. []byte keys, which can only be
+// held by interface keys, are compared by content instead of panicking
+func keyEqual[K comparable](a, b *K) bool {
+	if p, ok := any(a).(*interface{}); ok {
+		return equalAny(*p, *any(b).(*interface{}))
+	}
+	return *a == *b
+}
+
+func equalAny(a, b interface{}) bool {
+	if x, ok := a.([]byte); ok {
+		if y, ok := b.([]byte); ok {
+			return bytes.Equal(x, y)
+		}
+	}
+	return a == b
+}
+
+// cloneKey returns a private copy of a []byte key, so the caller mutating its
+// slice afterwards won't corrupt the map
+func cloneKey[K comparable](key K) K {
+	if p, ok := any(&key).(*interface{}); ok {
+		if b, ok := (*p).([]byte); ok {
+			*p = bytes.Clone(b)
+		}
 	}
+	return key
 }
 
 // memhash computes the hash of 'size' bytes of memory at addr
-func memhash(k0, k1 uint64, addr unsafe.Pointer, size int) uint64 {
-	sh := reflect.SliceHeader{
-		Data: uintptr(addr),
-		Len:  size,
-		Cap:  size,
+func memhash(hs Hasher, k0, k1 uint64, addr unsafe.Pointer, size int) uint64 {
+	return hs.Hash(k0, k1, unsafe.Slice((*byte)(addr), size))
+}
+
+// strhash computes the hash of a string without copying it
+func strhash(hs Hasher, k0, k1 uint64, s string) uint64 {
+	return hs.Hash(k0, k1, unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
+var (
+	hash64Type = reflect.TypeOf((*Hash64)(nil)).Elem()
+
+	bufPool = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 0, 64)
+			return &b
+		},
+	}
+)
+
+// isMemHashable returns true if values of type t are equal iff their bytes are
+// equal, i.e., t has no padding, float, string or interface in it
+func isMemHashable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return true
+	case reflect.Array:
+		return isMemHashable(t.Elem())
+	case reflect.Struct:
+		var size uintptr
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Name == "_" || !isMemHashable(f.Type) {
+				// blank fields are ignored by ==
+				return false
+			}
+			size += f.Type.Size()
+		}
+		return size == t.Size()
+	default:
+		return false
+	}
+}
+
+// hashValue computes the hash of any comparable value. For a value whose type
+// is memory-hashable, it hashes the same bytes as memhash does
+func hashValue(hs Hasher, k0, k1 uint64, v reflect.Value) uint64 {
+	if k := v.Kind(); k == reflect.Pointer || k == reflect.Chan || k == reflect.UnsafePointer {
+		// pointer identity
+		p := v.Pointer()
+		return memhash(hs, k0, k1, unsafe.Pointer(&p), int(unsafe.Sizeof(p)))
+	}
+	buf := bufPool.Get().(*[]byte)
+	*buf = appendValue((*buf)[:0], v)
+	h := hs.Hash(k0, k1, *buf)
+	bufPool.Put(buf)
+	return h
+}
+
+// appendValue appends the bytes of v to b, so that values equal under == yield
+// the same bytes
+func appendValue(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int8:
+		return append(b, byte(v.Int()))
+	case reflect.Int16:
+		return binary.NativeEndian.AppendUint16(b, uint16(v.Int()))
+	case reflect.Int32:
+		return binary.NativeEndian.AppendUint32(b, uint32(v.Int()))
+	case reflect.Int64:
+		return binary.NativeEndian.AppendUint64(b, uint64(v.Int()))
+	case reflect.Int:
+		return appendUint(b, uint64(v.Int()), intSize)
+	case reflect.Uint8:
+		return append(b, byte(v.Uint()))
+	case reflect.Uint16:
+		return binary.NativeEndian.AppendUint16(b, uint16(v.Uint()))
+	case reflect.Uint32:
+		return binary.NativeEndian.AppendUint32(b, uint32(v.Uint()))
+	case reflect.Uint64:
+		return binary.NativeEndian.AppendUint64(b, v.Uint())
+	case reflect.Uint, reflect.Uintptr:
+		return appendUint(b, v.Uint(), int(v.Type().Size()))
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return appendUint(b, uint64(v.Pointer()), int(unsafe.Sizeof(uintptr(0))))
+	case reflect.Float32:
+		return binary.NativeEndian.AppendUint32(b, math.Float32bits(float32(normFloat(v.Float()))))
+	case reflect.Float64:
+		return binary.NativeEndian.AppendUint64(b, math.Float64bits(normFloat(v.Float())))
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		b = binary.NativeEndian.AppendUint64(b, math.Float64bits(normFloat(real(c))))
+		return binary.NativeEndian.AppendUint64(b, math.Float64bits(normFloat(imag(c))))
+	case reflect.String:
+		// length prefix keeps {"a", "bc"} and {"ab", "c"} apart
+		s := v.String()
+		b = appendUint(b, uint64(len(s)), intSize)
+		return append(b, s...)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			b = appendValue(b, v.Index(i))
+		}
+		return b
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name != "_" {
+				b = appendValue(b, v.Field(i))
+			}
+		}
+		return b
+	case reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0)
+		}
+		v = v.Elem()
+		b = append(b, byte(v.Kind()))
+		return appendValue(b, v)
+	default:
+		panic(fmt.Errorf("unsupported key type %v", v.Type()))
+	}
+}
+
+func appendUint(b []byte, u uint64, size int) []byte {
+	if size == 4 {
+		return binary.NativeEndian.AppendUint32(b, uint32(u))
+	}
+	return binary.NativeEndian.AppendUint64(b, u)
+}
+
+// normFloat maps -0 to +0, as they are equal under ==
+func normFloat(f float64) float64 {
+	if f == 0 {
+		return 0
 	}
-	return siphash.Hash(k0, k1, *(*[]byte)(unsafe.Pointer(&sh)))
+	return f
 }
@@ -15,6 +15,8 @@
 package hashmap
 
 import (
+	"math"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -55,12 +57,133 @@ func TestHash(t *testing.T) {
 		{testHash64{value: 16}, testHash64{value: 16}.Sum64()},
 	}
 
-	h := &hmap{}
 	for _, test := range tests {
-		req.Equal(test.hash, h.hash(test.key))
+		req.Equal(test.hash, hashAny(SipHasher, 0, 0, test.key))
 	}
 }
 
+func TestHasherFor(t *testing.T) {
+	req := require.New(t)
+
+	// typed hasher agrees with hashAny on the same key
+	req.Equal(hashAny(SipHasher, 1, 2, uint8(16)), hasherFor[uint8]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, int8(16)), hasherFor[int8]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, uint16(16)), hasherFor[uint16]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, int16(16)), hasherFor[int16]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, uint32(16)), hasherFor[uint32]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, int32(16)), hasherFor[int32]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, uint64(16)), hasherFor[uint64]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, int64(16)), hasherFor[int64]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, uint(16)), hasherFor[uint]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, 16), hasherFor[int]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, "16"), hasherFor[string]()(SipHasher, 1, 2, "16"))
+	req.Equal(hashAny(SipHasher, 1, 2, testHash64{16}), hasherFor[testHash64]()(SipHasher, 1, 2, testHash64{16}))
+	req.Equal(hashAny(SipHasher, 1, 2, "16"), hasherFor[interface{}]()(SipHasher, 1, 2, "16"))
+
+	// named types hash by their underlying kind
+	type id int
+	type name string
+	req.Equal(hashAny(SipHasher, 1, 2, 16), hasherFor[id]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, "16"), hasherFor[name]()(SipHasher, 1, 2, "16"))
+
+	// unless they implement Hash64
+	req.Equal(testID(16).Sum64(), hasherFor[testID]()(SipHasher, 1, 2, 16))
+	req.Equal(hashAny(SipHasher, 1, 2, testID(16)), hasherFor[testID]()(SipHasher, 1, 2, 16))
+}
+
+func TestHashComparable(t *testing.T) {
+	req := require.New(t)
+
+	type (
+		tenant struct {
+			name string
+			id   int
+		}
+		point struct {
+			x, y int32
+		}
+		padded struct {
+			b bool
+			n int64
+		}
+		nested struct {
+			t tenant
+			v interface{}
+			f float64
+		}
+	)
+	var (
+		a, b = new(int), new(int)
+		ch   = make(chan int)
+		neg0 = math.Copysign(0, -1)
+	)
+
+	// keys equal under == yield the same hash
+	equal := []struct {
+		k1, k2 interface{}
+	}{
+		{tenant{"a", 1}, tenant{"a", 1}},
+		{point{1, 2}, point{1, 2}},
+		{padded{true, 2}, padded{true, 2}},
+		{[16]byte{1, 2, 3}, [16]byte{1, 2, 3}},
+		{[2]string{"a", "b"}, [2]string{"a", "b"}},
+		{true, true},
+		{0.0, neg0},
+		{float32(0), float32(neg0)},
+		{complex(0, 0), complex(neg0, neg0)},
+		{a, a},
+		{ch, ch},
+		{nested{tenant{"a", 1}, 1, 0}, nested{tenant{"a", 1}, 1, neg0}},
+		{nested{v: nil}, nested{v: nil}},
+	}
+	for _, e := range equal {
+		req.True(e.k1 == e.k2)
+		req.Equal(hashAny(SipHasher, 1, 2, e.k1), hashAny(SipHasher, 1, 2, e.k2))
+	}
+
+	// keys not equal under == yield different hashes
+	differ := []struct {
+		k1, k2 interface{}
+	}{
+		{tenant{"a", 1}, tenant{"a", 2}},
+		{tenant{"ab", 1}, tenant{"a", 1}},
+		{[2]string{"a", "bc"}, [2]string{"ab", "c"}},
+		{point{1, 2}, point{2, 1}},
+		{[16]byte{1}, [16]byte{2}},
+		{true, false},
+		{1.0, 2.0},
+		{a, b},
+		{nested{v: 1}, nested{v: int64(1)}},
+		{nested{v: nil}, nested{v: 0}},
+	}
+	for _, d := range differ {
+		req.False(d.k1 == d.k2)
+		req.NotEqual(hashAny(SipHasher, 1, 2, d.k1), hashAny(SipHasher, 1, 2, d.k2))
+	}
+
+	// typed hasher agrees with hashAny
+	req.Equal(hashAny(SipHasher, 1, 2, tenant{"a", 1}), hasherFor[tenant]()(SipHasher, 1, 2, tenant{"a", 1}))
+	req.Equal(hashAny(SipHasher, 1, 2, point{1, 2}), hasherFor[point]()(SipHasher, 1, 2, point{1, 2}))
+	req.Equal(hashAny(SipHasher, 1, 2, padded{true, 2}), hasherFor[padded]()(SipHasher, 1, 2, padded{true, 2}))
+	req.Equal(hashAny(SipHasher, 1, 2, [16]byte{1}), hasherFor[[16]byte]()(SipHasher, 1, 2, [16]byte{1}))
+	req.Equal(hashAny(SipHasher, 1, 2, neg0), hasherFor[float64]()(SipHasher, 1, 2, 0))
+	req.Equal(hashAny(SipHasher, 1, 2, true), hasherFor[bool]()(SipHasher, 1, 2, true))
+	req.Equal(hashAny(SipHasher, 1, 2, a), hasherFor[*int]()(SipHasher, 1, 2, a))
+	req.Equal(hashAny(SipHasher, 1, 2, nested{v: "a"}), hasherFor[nested]()(SipHasher, 1, 2, nested{v: "a"}))
+
+	// memory-hashable types
+	req.True(isMemHashable(reflect.TypeOf(point{})))
+	req.True(isMemHashable(reflect.TypeOf([16]byte{})))
+	req.True(isMemHashable(reflect.TypeOf(a)))
+	req.False(isMemHashable(reflect.TypeOf(padded{})))
+	req.False(isMemHashable(reflect.TypeOf(tenant{})))
+	req.False(isMemHashable(reflect.TypeOf(0.0)))
+
+	// uncomparable values still panic
+	req.Panics(func() { hashAny(SipHasher, 1, 2, map[int]int{}) })
+	req.Panics(func() { hashAny(SipHasher, 1, 2, nested{v: []int{1}}) })
+}
+
 type testHash64 struct {
 	value int
 }
@@ -68,3 +191,9 @@ type testHash64 struct {
 func (th testHash64) Sum64() uint64 {
 	return uint64(th.value*th.value%65535)<<33 + 1
 }
+
+type testID uint64
+
+func (id testID) Sum64() uint64 {
+	return uint64(id) * 0x9e3779b97f4a7c15
+}
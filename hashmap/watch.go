@@ -0,0 +1,362 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// event types
+const (
+	EventSet    EventType = iota + 1 // the key is set while absent or expired
+	EventUpdate                      // the value of a present key is replaced
+	EventDelete                      // the key is deleted or evicted
+	EventExpire                      // the expired entry is removed or overwritten
+)
+
+// backpressure policies of a watcher whose buffer is full
+const (
+	// BackpressureDrop drops the event, counted by Watcher.Dropped. It is the
+	// default, so a slow watcher never holds up writers
+	BackpressureDrop Backpressure = iota
+	// BackpressureBlock blocks the writer until the event is buffered or the
+	// watcher is closed. The watcher must not write the map while it is
+	// behind, or it waits for itself
+	BackpressureBlock
+	// BackpressureCoalesce keeps one pending event per key, the latest one
+	// replaces the earlier in place, so the watcher sees the latest change of
+	// every key. Events are sent directly while the buffer has room and nothing
+	// is pending. Pending events grow with the number of changed keys
+	BackpressureCoalesce
+)
+
+type (
+	// EventType is the kind of change of an Event
+	EventType uint8
+
+	// Event is a change of a key. Value is the new value for EventSet and
+	// EventUpdate, or the removed value for EventDelete and EventExpire
+	Event[K comparable, V any] struct {
+		Type  EventType
+		Key   K
+		Value V
+	}
+
+	// Backpressure is what a watcher does once its buffer is full
+	Backpressure uint8
+
+	// WatchOption provides options for Watch and WatchAll
+	WatchOption func(*watchOptions)
+
+	watchOptions struct {
+		size   int          // buffer size of the channel
+		policy Backpressure // applies once the buffer is full
+	}
+
+	// Watcher receives the events of a key, or all keys, on C until Close.
+	//
+	// An event is sent once its write is done, so events of writes racing on
+	// the same key may arrive in either order; Get returns the latest value.
+	// Clear sends no events
+	Watcher[K comparable, V any] struct {
+		C <-chan Event[K, V]
+
+		c       chan Event[K, V]
+		h       *Map[K, V]
+		all     bool   // watches all keys
+		key     K      // watched key unless all
+		hash    uint64 // hash of key
+		policy  Backpressure
+		done    chan struct{} // closed by Close
+		stop    sync.Once     // closes done
+		mutex   sync.RWMutex  // held by senders, so C is closed after the last send
+		closed  bool
+		dropped uint64
+
+		// pending events of BackpressureCoalesce, sent in order by pump
+		pendLock sync.Mutex
+		pending  []*pending[K, V]
+		index    map[uint64][]*pending[K, V] // pending events by hash of the key
+		sending  bool                        // pump is sending an event taken off pending
+		ready    chan struct{}
+		pumped   chan struct{} // closed once pump returns
+	}
+
+	pending[K comparable, V any] struct {
+		hash uint64
+		ev   Event[K, V]
+	}
+
+	// watchers is the immutable set of watchers, replaced as a whole when a
+	// watcher is added or closed
+	watchers[K comparable, V any] struct {
+		all  []*Watcher[K, V]
+		keys map[uint64][]*Watcher[K, V] // by hash of the watched key
+	}
+)
+
+// WatchBufferOption sets the buffer size of the channel, 64 by default
+func WatchBufferOption(size int) WatchOption {
+	return func(o *watchOptions) {
+		if size >= 0 {
+			o.size = size
+		}
+	}
+}
+
+// WatchBackpressureOption sets the policy once the buffer is full
+func WatchBackpressureOption(p Backpressure) WatchOption {
+	return func(o *watchOptions) {
+		o.policy = p
+	}
+}
+
+// Watch returns a watcher receiving the events of the key
+func (h *Map[K, V]) Watch(key K, opts ...WatchOption) *Watcher[K, V] {
+	key = cloneKey(key)
+	return h.watch(false, key, h.hash(key), opts)
+}
+
+// WatchAll returns a watcher receiving the events of all keys
+func (h *Map[K, V]) WatchAll(opts ...WatchOption) *Watcher[K, V] {
+	var key K
+	return h.watch(true, key, 0, opts)
+}
+
+func (h *Map[K, V]) watch(all bool, key K, hash uint64, opts []WatchOption) *Watcher[K, V] {
+	o := watchOptions{size: 64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	w := Watcher[K, V]{
+		c:      make(chan Event[K, V], o.size),
+		h:      h,
+		all:    all,
+		key:    key,
+		hash:   hash,
+		policy: o.policy,
+		done:   make(chan struct{}),
+	}
+	w.C = w.c
+	if w.policy == BackpressureCoalesce {
+		w.index = make(map[uint64][]*pending[K, V])
+		w.ready = make(chan struct{}, 1)
+		w.pumped = make(chan struct{})
+		go w.pump()
+	}
+	h.updateWatchers(func(ws *watchers[K, V]) {
+		if all {
+			ws.all = append(ws.all, &w)
+		} else {
+			ws.keys[hash] = append(ws.keys[hash], &w)
+		}
+	})
+	return &w
+}
+
+// Close stops the watcher and closes C. Events buffered in C can still be
+// received
+func (w *Watcher[K, V]) Close() {
+	w.h.updateWatchers(func(ws *watchers[K, V]) {
+		ws.all = without(ws.all, w)
+		if ws.keys[w.hash] = without(ws.keys[w.hash], w); len(ws.keys[w.hash]) == 0 {
+			delete(ws.keys, w.hash)
+		}
+	})
+	// wake up blocked senders and the pump before waiting for them
+	w.stop.Do(func() {
+		close(w.done)
+	})
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if w.policy == BackpressureCoalesce {
+		<-w.pumped
+	}
+	close(w.c)
+}
+
+// Dropped returns the number of events dropped by BackpressureDrop
+func (w *Watcher[K, V]) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// without returns a copy of s without v
+func without[T any](s []*T, v *T) []*T {
+	var rest []*T
+	for _, p := range s {
+		if p != v {
+			rest = append(rest, p)
+		}
+	}
+	return rest
+}
+
+// updateWatchers publishes a copy of the watchers changed by f
+func (h *Map[K, V]) updateWatchers(f func(*watchers[K, V])) {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	ws := watchers[K, V]{
+		keys: make(map[uint64][]*Watcher[K, V]),
+	}
+	if old := (*watchers[K, V])(atomic.LoadPointer(&h.watchers)); old != nil {
+		ws.all = append(ws.all, old.all...)
+		for hash, v := range old.keys {
+			ws.keys[hash] = append([]*Watcher[K, V](nil), v...)
+		}
+	}
+	f(&ws)
+	if len(ws.all) == 0 && len(ws.keys) == 0 {
+		// no watcher, writes skip notify with a nil check
+		atomic.StorePointer(&h.watchers, nil)
+		return
+	}
+	atomic.StorePointer(&h.watchers, unsafe.Pointer(&ws))
+}
+
+// notifyStored sends the events of e stored over old, nil if the key was absent
+func (h *Map[K, V]) notifyStored(hash uint64, key *K, old, e *entry[V]) {
+	if atomic.LoadPointer(&h.watchers) == nil {
+		return
+	}
+	switch {
+	case old == nil:
+		h.notify(hash, Event[K, V]{Type: EventSet, Key: *key, Value: e.val})
+	case old.expired():
+		h.notify(hash, Event[K, V]{Type: EventExpire, Key: *key, Value: old.val})
+		h.notify(hash, Event[K, V]{Type: EventSet, Key: *key, Value: e.val})
+	default:
+		h.notify(hash, Event[K, V]{Type: EventUpdate, Key: *key, Value: e.val})
+	}
+}
+
+// notifyRemoved sends the event of the removed entry e
+func (h *Map[K, V]) notifyRemoved(hash uint64, key *K, e *entry[V]) {
+	if atomic.LoadPointer(&h.watchers) == nil {
+		return
+	}
+	if e.expired() {
+		h.notify(hash, Event[K, V]{Type: EventExpire, Key: *key, Value: e.val})
+	} else {
+		h.notify(hash, Event[K, V]{Type: EventDelete, Key: *key, Value: e.val})
+	}
+}
+
+func (h *Map[K, V]) notify(hash uint64, ev Event[K, V]) {
+	ws := (*watchers[K, V])(atomic.LoadPointer(&h.watchers))
+	if ws == nil {
+		return
+	}
+	for _, w := range ws.all {
+		w.send(hash, ev)
+	}
+	for _, w := range ws.keys[hash] {
+		if keyEqual(&w.key, &ev.Key) {
+			w.send(hash, ev)
+		}
+	}
+}
+
+func (w *Watcher[K, V]) send(hash uint64, ev Event[K, V]) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return
+	}
+	switch w.policy {
+	case BackpressureBlock:
+		select {
+		case w.c <- ev:
+		case <-w.done:
+		}
+	case BackpressureCoalesce:
+		w.coalesce(hash, ev)
+	default:
+		select {
+		case w.c <- ev:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	}
+}
+
+// coalesce sends the event if nothing is ahead of it and the buffer has room.
+// Otherwise it replaces the pending event of the key, or queues the event for
+// the pump
+func (w *Watcher[K, V]) coalesce(hash uint64, ev Event[K, V]) {
+	w.pendLock.Lock()
+	if len(w.pending) == 0 && !w.sending {
+		select {
+		case w.c <- ev:
+			w.pendLock.Unlock()
+			return
+		default:
+		}
+	}
+	for _, p := range w.index[hash] {
+		if keyEqual(&p.ev.Key, &ev.Key) {
+			p.ev = ev
+			w.pendLock.Unlock()
+			return
+		}
+	}
+	p := &pending[K, V]{hash: hash, ev: ev}
+	w.pending = append(w.pending, p)
+	w.index[hash] = append(w.index[hash], p)
+	w.pendLock.Unlock()
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pump sends the pending events in order until the watcher is closed
+func (w *Watcher[K, V]) pump() {
+	defer close(w.pumped)
+	for {
+		select {
+		case <-w.ready:
+		case <-w.done:
+			return
+		}
+		for {
+			w.pendLock.Lock()
+			if len(w.pending) == 0 {
+				w.sending = false
+				w.pendLock.Unlock()
+				break
+			}
+			p := w.pending[0]
+			w.pending[0] = nil
+			w.pending = w.pending[1:]
+			if w.index[p.hash] = without(w.index[p.hash], p); len(w.index[p.hash]) == 0 {
+				delete(w.index, p.hash)
+			}
+			// keep later events off the channel until p is sent
+			w.sending = true
+			w.pendLock.Unlock()
+			select {
+			case w.c <- p.ev:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
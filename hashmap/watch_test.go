@@ -0,0 +1,158 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[string, int]()
+	w := m.Watch("a")
+	all := m.WatchAll()
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("a", 3)
+	m.Del("a")
+	m.Del("c")
+	m.SetWithTTL("a", 4, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	m.Set("a", 5)
+	m.SetMany([]string{"a", "b"}, []int{6, 7})
+	m.DelMany([]string{"a", "b"})
+
+	events := []Event[string, int]{
+		{EventSet, "a", 1},
+		{EventUpdate, "a", 3},
+		{EventDelete, "a", 3},
+		{EventSet, "a", 4},
+		{EventExpire, "a", 4},
+		{EventSet, "a", 5},
+		{EventUpdate, "a", 6},
+		{EventDelete, "a", 6},
+	}
+	for _, ev := range events {
+		req.Equal(ev, <-w.C)
+	}
+	w.Close()
+	_, ok := <-w.C
+	req.False(ok)
+
+	var got []Event[string, int]
+	for len(all.C) > 0 {
+		got = append(got, <-all.C)
+	}
+	req.Len(got, len(events)+3)
+	req.Equal(Event[string, int]{EventSet, "b", 2}, got[1])
+
+	// closed watchers get no more events
+	all.Close()
+	all.Close()
+	m.Set("a", 1)
+	_, ok = <-all.C
+	req.False(ok)
+	req.True(m.watchers == nil)
+}
+
+func TestWatchBackpressure(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int]()
+
+	// drop
+	w := m.WatchAll(WatchBufferOption(1))
+	for i := 0; i < 10; i++ {
+		m.Set(i, i)
+	}
+	req.Equal(Event[int, int]{EventSet, 0, 0}, <-w.C)
+	req.Equal(uint64(9), w.Dropped())
+	w.Close()
+
+	// block
+	w = m.Watch(0, WatchBufferOption(0), WatchBackpressureOption(BackpressureBlock))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 10; i++ {
+			m.Set(0, i)
+		}
+	}()
+	for i := 1; i <= 5; i++ {
+		req.Equal(Event[int, int]{EventUpdate, 0, i}, <-w.C)
+	}
+	// a blocked writer goes on once the watcher is closed
+	w.Close()
+	wg.Wait()
+	v, _ := m.Get(0)
+	req.Equal(10, v)
+
+	// coalesce
+	w = m.WatchAll(WatchBufferOption(0), WatchBackpressureOption(BackpressureCoalesce))
+	for i := 0; i < 100; i++ {
+		m.Set(0, i)
+		m.Set(1, i)
+	}
+	var last [2]int
+	for last[0] != 99 || last[1] != 99 {
+		ev := <-w.C
+		req.Equal(EventUpdate, ev.Type)
+		req.True(ev.Value > last[ev.Key] || ev.Value == 0)
+		last[ev.Key] = ev.Value
+	}
+	select {
+	case ev := <-w.C:
+		req.Fail("unexpected event", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+	w.Close()
+	_, ok := <-w.C
+	req.False(ok)
+
+	// coalesce sends directly while the buffer has room
+	w = m.WatchAll(WatchBufferOption(4), WatchBackpressureOption(BackpressureCoalesce))
+	for i := 0; i < 4; i++ {
+		m.Set(i, i)
+	}
+	req.Len(w.C, 4)
+	w.pendLock.Lock()
+	req.Empty(w.pending)
+	w.pendLock.Unlock()
+	// then keeps the latest change of each key, in order
+	for i := 0; i < 4; i++ {
+		m.Set(i, 10+i)
+		m.Set(i, 20+i)
+	}
+	for i := 0; i < 4; i++ {
+		req.Equal(Event[int, int]{EventUpdate, i, i}, <-w.C)
+	}
+	var latest [4]int
+	for latest != [4]int{20, 21, 22, 23} {
+		ev := <-w.C
+		req.Equal(EventUpdate, ev.Type)
+		req.True(ev.Value > latest[ev.Key])
+		latest[ev.Key] = ev.Value
+	}
+	w.Close()
+	_, ok = <-w.C
+	req.False(ok)
+}
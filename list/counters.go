@@ -0,0 +1,76 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// Counters are the running totals of a queue or stack
+	Counters struct {
+		Adds       uint64 // number of Enque/Push calls, counted with CountersOption
+		Removes    uint64 // number of items removed by Deque/Pop, counted with CountersOption
+		CASRetries uint64 // number of failed CAS when adding or removing an item, counted with CountersOption
+	}
+
+	// counters are the counters of a queue or stack
+	counters struct {
+		counting   bool // count Enque/Deque or Push/Pop calls and CAS retries
+		adds       uint64
+		removes    uint64
+		casRetries uint64
+	}
+
+	options struct {
+		counters bool // count Enque/Deque or Push/Pop calls and CAS retries
+	}
+)
+
+// Option provides options for instantiating a queue or stack
+type Option func(*options)
+
+// CountersOption counts the items added and removed, and the CAS retries,
+// reported by Counters. It is off by default, as every call then updates a
+// shared counter
+func CountersOption() Option {
+	return func(o *options) {
+		o.counters = true
+	}
+}
+
+func newCounters(opts []Option) counters {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return counters{counting: o.counters}
+}
+
+// Counters returns the running totals of the list
+func (c *counters) Counters() Counters {
+	return Counters{
+		Adds:       atomic.LoadUint64(&c.adds),
+		Removes:    atomic.LoadUint64(&c.removes),
+		CASRetries: atomic.LoadUint64(&c.casRetries),
+	}
+}
+
+// tally adds 1 to an operation counter, if counters are enabled
+func (c *counters) tally(counter *uint64) {
+	if c.counting {
+		atomic.AddUint64(counter, 1)
+	}
+}
@@ -23,15 +23,17 @@ type (
 	queue struct {
 		count      uint64
 		head, tail *node
+		counters
 	}
 )
 
 // NewQueue creates a new queue
-func NewQueue() *queue {
+func NewQueue(opts ...Option) *queue {
 	empty := node{}
 	return &queue{
-		head: &empty,
-		tail: &empty,
+		head:     &empty,
+		tail:     &empty,
+		counters: newCounters(opts),
 	}
 }
 
@@ -49,8 +51,10 @@ func (q *queue) Enque(v interface{}) {
 		if tail.casNext(nil, unsafe.Pointer(&n)) {
 			atomic.StorePointer(tailAddr, unsafe.Pointer(&n))
 			atomic.AddUint64(&q.count, 1)
+			q.tally(&q.adds)
 			return
 		}
+		q.tally(&q.casRetries)
 	}
 }
 
@@ -64,7 +68,9 @@ func (q *queue) Deque() interface{} {
 		}
 		if casAddr(headAddr, head, unsafe.Pointer(n)) {
 			atomic.AddUint64(&q.count, ^uint64(0))
+			q.tally(&q.removes)
 			return *(*interface{})(n.value())
 		}
+		q.tally(&q.casRetries)
 	}
 }
@@ -15,6 +15,8 @@
 package list
 
 import (
+	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -42,3 +44,45 @@ func TestQueue(t *testing.T) {
 	req.Equal(0, q.Len())
 	req.Nil(q.Deque())
 }
+
+func TestQueueCounters(t *testing.T) {
+	req := require.New(t)
+
+	// the load retries CAS, but nothing is counted without the option
+	q := NewQueue(CountersOption())
+	for i := 0; i < 100 && q.Counters().CASRetries == 0; i++ {
+		contend(q.Enque, func() { q.Deque() })
+	}
+	req.True(q.Counters().CASRetries > 0)
+	q = NewQueue()
+	contend(q.Enque, func() { q.Deque() })
+	req.Equal(Counters{}, q.Counters())
+
+	q = NewQueue(CountersOption())
+	for i := 0; i < 3; i++ {
+		q.Enque(i)
+	}
+	q.Deque()
+	q.Deque()
+	q.Deque()
+	req.Nil(q.Deque())
+	req.Equal(Counters{Adds: 3, Removes: 3}, q.Counters())
+}
+
+// contend adds and removes items from many go-routines, on more threads than
+// cores so that a CAS fails when its thread is preempted
+func contend(add func(interface{}), remove func()) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20000; j++ {
+				add(j)
+				remove()
+			}
+		}()
+	}
+	wg.Wait()
+}
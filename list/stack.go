@@ -23,14 +23,16 @@ type (
 	stack struct {
 		count uint64
 		head  *node
+		counters
 	}
 )
 
 // NewStack creates a new stack
-func NewStack() *stack {
+func NewStack(opts ...Option) *stack {
 	var empty interface{}
 	return &stack{
-		head: &node{val: unsafe.Pointer(&empty)},
+		head:     &node{val: unsafe.Pointer(&empty)},
+		counters: newCounters(opts),
 	}
 }
 
@@ -48,8 +50,10 @@ func (s *stack) Push(v interface{}) {
 		n.nxt = head
 		if casAddr(headAddr, head, unsafe.Pointer(&n)) {
 			atomic.AddUint64(&s.count, 1)
+			s.tally(&s.adds)
 			return
 		}
+		s.tally(&s.casRetries)
 	}
 }
 
@@ -63,8 +67,10 @@ func (s *stack) Pop() interface{} {
 		}
 		if casAddr(headAddr, unsafe.Pointer(head), unsafe.Pointer(n)) {
 			atomic.AddUint64(&s.count, ^uint64(0))
+			s.tally(&s.removes)
 			return *(*interface{})(head.value())
 		}
+		s.tally(&s.casRetries)
 	}
 }
 
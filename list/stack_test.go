@@ -44,3 +44,20 @@ func TestStack(t *testing.T) {
 	req.Nil(s.Peek())
 	req.Nil(s.Pop())
 }
+
+func TestStackCounters(t *testing.T) {
+	req := require.New(t)
+
+	// nothing is counted without the option, not even CAS retries
+	s := NewStack()
+	contend(s.Push, func() { s.Pop() })
+	req.Equal(Counters{}, s.Counters())
+
+	s = NewStack(CountersOption())
+	for i := 0; i < 3; i++ {
+		s.Push(i)
+	}
+	s.Pop()
+	req.Equal(1, s.Peek())
+	req.Equal(Counters{Adds: 3, Removes: 1}, s.Counters())
+}
@@ -15,6 +15,12 @@
 package lockfree
 
 import (
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"iter"
+	"time"
+
 	"github.com/dustinxie/lockfree/hashmap"
 )
 
@@ -30,9 +36,59 @@ type (
 		// map[key] = value
 		Set(key, value interface{})
 
+		// map[key] = value, which expires after ttl
+		SetWithTTL(key, value interface{}, ttl time.Duration)
+
 		// delete(map, key)
 		Del(key interface{})
 
+		// returns the existing value if present, otherwise stores and returns
+		// the given value. loaded is true if the value was loaded
+		LoadOrStore(key, value interface{}) (actual interface{}, loaded bool)
+
+		// deletes the key and returns its previous value if any
+		LoadAndDelete(key interface{}) (value interface{}, loaded bool)
+
+		// sets the value and returns the previous value if any
+		Swap(key, value interface{}) (previous interface{}, loaded bool)
+
+		// sets the value to new if the existing value is equal to old
+		CompareAndSwap(key, old, new interface{}) (swapped bool)
+
+		// deletes the key if its value is equal to old
+		CompareAndDelete(key, old interface{}) (deleted bool)
+
+		// sets the value to f(old, present), or deletes the key if f returns
+		// false. f is retried until the result is stored with CAS
+		Compute(key interface{}, f func(old interface{}, present bool) (interface{}, bool)) (interface{}, bool)
+
+		// returns the existing value if present, otherwise stores and returns
+		// the result of f. loaded is true if the value was loaded
+		ComputeIfAbsent(key interface{}, f func() interface{}) (actual interface{}, loaded bool)
+
+		// stores the value if absent, otherwise sets the value to
+		// f(old, value), or deletes the key if f returns false
+		Merge(key, value interface{}, f func(old, value interface{}) (interface{}, bool)) (interface{}, bool)
+
+		// gets the keys in hash order, a bucket is walked once for all its
+		// keys. found[i] reports whether keys[i] is present
+		GetMany(keys []interface{}) (values []interface{}, found []bool)
+
+		// sets values[i] for keys[i] in hash order, and expands the map once
+		// at the end. inserted[i] is false if keys[i] is updated
+		SetMany(keys, values []interface{}) (inserted []bool)
+
+		// deletes the keys in hash order, and shrinks the map once at the
+		// end. deleted[i] reports whether keys[i] was present
+		DelMany(keys []interface{}) (deleted []bool)
+
+		// returns a watcher receiving the set, update, delete and expire
+		// events of the key on its channel C until Close
+		Watch(key interface{}, opts ...hashmap.WatchOption) *hashmap.Watcher[interface{}, interface{}]
+
+		// returns a watcher receiving the events of all keys
+		WatchAll(opts ...hashmap.WatchOption) *hashmap.Watcher[interface{}, interface{}]
+
 		// call this before for k, v := range map
 		Lock()
 
@@ -41,10 +97,217 @@ type (
 
 		// returns next <k, v> in the map
 		Next() (interface{}, interface{}, bool)
+
+		// returns an iterator that does not lock the map
+		Iter() Iterator[interface{}, interface{}]
+
+		// returns n iterators over disjoint parts of the map, to walk it in
+		// parallel
+		Partitions(n int) []Iterator[interface{}, interface{}]
+
+		// calls f for each <k, v> from the given number of goroutines,
+		// stops once f returns false
+		ParallelRange(workers int, f func(key, value interface{}) bool)
+
+		// for k, v := range map { if !f(k, v) { break } }
+		Range(f func(key, value interface{}) bool)
+
+		// for k, v := range m.All()
+		All() iter.Seq2[interface{}, interface{}]
+
+		// for k := range m.Keys()
+		Keys() iter.Seq[interface{}]
+
+		// for v := range m.Values()
+		Values() iter.Seq[interface{}]
+
+		// returns the keys at a single point in time
+		SnapshotKeys() []interface{}
+
+		// returns the values at a single point in time
+		SnapshotValues() []interface{}
+
+		// copies the map at a single point in time
+		ToMap() map[interface{}]interface{}
+
+		// removes all entries at once, may drop writes running at the same
+		// time, and sends no events to watchers
+		Clear()
+
+		// clears the map and zeroes the counters of Stats
+		Reset()
+
+		// returns the statistics of the map
+		Stats() hashmap.Stats
+
+		// returns the running totals of the map, cheaper than Stats
+		Counters() hashmap.Counters
+
+		// encodes a point-in-time view of the map, and decodes entries into
+		// the map. Keys and values of user types are registered by
+		// hashmap.Register to be decoded into interface{}
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+		gob.GobEncoder
+		gob.GobDecoder
+		json.Marshaler
+		json.Unmarshaler
+	}
+
+	// Map is a type-safe map[K]V
+	Map[K comparable, V any] interface {
+		// len(map)
+		Len() int
+
+		// v, ok := map[key]
+		Get(key K) (V, bool)
+
+		// map[key] = value
+		Set(key K, value V)
+
+		// map[key] = value, which expires after ttl
+		SetWithTTL(key K, value V, ttl time.Duration)
+
+		// delete(map, key)
+		Del(key K)
+
+		// returns the existing value if present, otherwise stores and returns
+		// the given value. loaded is true if the value was loaded
+		LoadOrStore(key K, value V) (actual V, loaded bool)
+
+		// deletes the key and returns its previous value if any
+		LoadAndDelete(key K) (value V, loaded bool)
+
+		// sets the value and returns the previous value if any
+		Swap(key K, value V) (previous V, loaded bool)
+
+		// sets the value to new if the existing value is equal to old
+		CompareAndSwap(key K, old, new V) (swapped bool)
+
+		// deletes the key if its value is equal to old
+		CompareAndDelete(key K, old V) (deleted bool)
+
+		// sets the value to f(old, present), or deletes the key if f returns
+		// false. f is retried until the result is stored with CAS
+		Compute(key K, f func(old V, present bool) (V, bool)) (V, bool)
+
+		// returns the existing value if present, otherwise stores and returns
+		// the result of f. loaded is true if the value was loaded
+		ComputeIfAbsent(key K, f func() V) (actual V, loaded bool)
+
+		// stores the value if absent, otherwise sets the value to
+		// f(old, value), or deletes the key if f returns false
+		Merge(key K, value V, f func(old, value V) (V, bool)) (V, bool)
+
+		// gets the keys in hash order, a bucket is walked once for all its
+		// keys. found[i] reports whether keys[i] is present
+		GetMany(keys []K) (values []V, found []bool)
+
+		// sets values[i] for keys[i] in hash order, and expands the map once
+		// at the end. inserted[i] is false if keys[i] is updated
+		SetMany(keys []K, values []V) (inserted []bool)
+
+		// deletes the keys in hash order, and shrinks the map once at the
+		// end. deleted[i] reports whether keys[i] was present
+		DelMany(keys []K) (deleted []bool)
+
+		// returns a watcher receiving the set, update, delete and expire
+		// events of the key on its channel C until Close
+		Watch(key K, opts ...hashmap.WatchOption) *hashmap.Watcher[K, V]
+
+		// returns a watcher receiving the events of all keys
+		WatchAll(opts ...hashmap.WatchOption) *hashmap.Watcher[K, V]
+
+		// returns an iterator that does not lock the map
+		Iter() Iterator[K, V]
+
+		// returns n iterators over disjoint parts of the map, to walk it in
+		// parallel
+		Partitions(n int) []Iterator[K, V]
+
+		// calls f for each <k, v> from the given number of goroutines,
+		// stops once f returns false
+		ParallelRange(workers int, f func(key K, value V) bool)
+
+		// for k, v := range map { if !f(k, v) { break } }
+		Range(f func(key K, value V) bool)
+
+		// for k, v := range m.All()
+		All() iter.Seq2[K, V]
+
+		// for k := range m.Keys()
+		Keys() iter.Seq[K]
+
+		// for v := range m.Values()
+		Values() iter.Seq[V]
+
+		// returns the keys at a single point in time
+		SnapshotKeys() []K
+
+		// returns the values at a single point in time
+		SnapshotValues() []V
+
+		// copies the map at a single point in time
+		ToMap() map[K]V
+
+		// removes all entries at once, may drop writes running at the same
+		// time, and sends no events to watchers
+		Clear()
+
+		// clears the map and zeroes the counters of Stats
+		Reset()
+
+		// returns the statistics of the map
+		Stats() hashmap.Stats
+
+		// returns the running totals of the map, cheaper than Stats
+		Counters() hashmap.Counters
+
+		// encodes a point-in-time view of the map, and decodes entries into
+		// the map. Keys and values of user types are registered by
+		// hashmap.Register to be decoded into interface{}
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+		gob.GobEncoder
+		gob.GobDecoder
+		json.Marshaler
+		json.Unmarshaler
+	}
+
+	// Iterator walks a map without locking it. Many iterators can run at the
+	// same time as each other and as Get/Set/Del, each key is returned at most
+	// once, and entries set or deleted during the walk may or may not be seen
+	Iterator[K comparable, V any] interface {
+		// returns next <k, v> in the map
+		Next() (K, V, bool)
+	}
+
+	// hashMap is a thin wrapper that returns the iterator as an interface
+	hashMap[K comparable, V any] struct {
+		*hashmap.Map[K, V]
 	}
 )
 
-// NewHashMap creates a new hashmap
+// NewHashMap creates a new hashmap, which is the type-safe map instantiated
+// with interface{} key and value
 func NewHashMap(opts ...hashmap.Option) HashMap {
-	return hashmap.New(opts...)
+	return &hashMap[interface{}, interface{}]{hashmap.New(opts...)}
+}
+
+// NewMap creates a new type-safe hashmap
+func NewMap[K comparable, V any](opts ...hashmap.Option) Map[K, V] {
+	return &hashMap[K, V]{hashmap.NewMap[K, V](opts...)}
+}
+
+func (m hashMap[K, V]) Iter() Iterator[K, V] {
+	return m.Map.Iter()
+}
+
+func (m hashMap[K, V]) Partitions(n int) []Iterator[K, V] {
+	its := m.Map.Partitions(n)
+	parts := make([]Iterator[K, V], len(its))
+	for i, it := range its {
+		parts[i] = it
+	}
+	return parts
 }
@@ -15,6 +15,8 @@
 package lockfree
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 	"testing"
 
@@ -50,9 +52,94 @@ func TestNewHashMap(t *testing.T) {
 	wg.Wait()
 }
 
+func TestNewMap(t *testing.T) {
+	req := require.New(t)
+
+	m := NewMap[int, int](hashmap.BucketSizeOption(8))
+	// test 4 threads
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	for i := 0; i < 4; i++ {
+		go func(start, end int) {
+			for i := start; i < end; i++ {
+				m.Set(i, i*i)
+			}
+			for i := start; i < end; i++ {
+				v, ok := m.Get(i)
+				req.True(ok)
+				req.Equal(i*i, v)
+			}
+			for i := start; i < end; i++ {
+				m.Del(i)
+			}
+			wg.Done()
+		}(i*10000, (i+1)*10000)
+	}
+	wg.Wait()
+	req.Zero(m.Len())
+
+	for i := 0; i < 100; i++ {
+		m.Set(i, i*i)
+	}
+	it := m.Iter()
+	var total int
+	for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+		req.Equal(k*k, v)
+		total++
+	}
+	req.Equal(100, total)
+	req.Equal(100, m.Stats().Count)
+
+	s := NewMap[string, []byte]()
+	s.Set("a", []byte("a"))
+	v, ok := s.Get("a")
+	req.True(ok)
+	req.Equal([]byte("a"), v)
+	v, ok = s.Get("b")
+	req.False(ok)
+	req.Nil(v)
+}
+
+func TestMarshal(t *testing.T) {
+	req := require.New(t)
+
+	m := NewHashMap()
+	m.Set("a", 1)
+	m.Set(2, "b")
+	data, err := m.MarshalBinary()
+	req.NoError(err)
+	m1 := NewHashMap()
+	req.NoError(m1.UnmarshalBinary(data))
+	req.Equal(2, m1.Len())
+	v, _ := m1.Get("a")
+	req.Equal(1, v)
+
+	tm := NewMap[string, int]()
+	tm.Set("a", 1)
+	data, err = json.Marshal(tm)
+	req.NoError(err)
+	tm1 := NewMap[string, int]()
+	req.NoError(json.Unmarshal(data, tm1))
+	n, ok := tm1.Get("a")
+	req.True(ok)
+	req.Equal(1, n)
+}
+
 func BenchmarkLockfreeHashMap(b *testing.B) {
+	benchmarkHashMap(b)
+}
+
+func BenchmarkLockfreeHashMapXXHash(b *testing.B) {
+	benchmarkHashMap(b, hashmap.HasherOption(hashmap.XXHasher))
+}
+
+func BenchmarkLockfreeHashMapMapHash(b *testing.B) {
+	benchmarkHashMap(b, hashmap.HasherOption(hashmap.MapHasher))
+}
+
+func benchmarkHashMap(b *testing.B, opts ...hashmap.Option) {
 	for i := 0; i < b.N; i++ {
-		m := NewHashMap()
+		m := NewHashMap(opts...)
 		wg := sync.WaitGroup{}
 		wg.Add(10)
 		for i := 0; i < 10; i++ {
@@ -79,6 +166,40 @@ func BenchmarkLockfreeHashMap(b *testing.B) {
 	}
 }
 
+func BenchmarkLockfreeHashMapBatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		m := NewHashMap()
+		wg := sync.WaitGroup{}
+		wg.Add(10)
+		for i := 0; i < 10; i++ {
+			go func(start, end int) {
+				// batches of 1000 keys
+				for n := start; n < end; n += 1000 {
+					keys := make([]interface{}, 1000)
+					values := make([]interface{}, 1000)
+					for i := range keys {
+						keys[i] = n + i
+						values[i] = (n + i) * (n + i)
+					}
+					m.SetMany(keys, values)
+					vs, found := m.GetMany(keys)
+					for i := range keys {
+						if !found[i] {
+							b.Error("key not exist")
+						}
+						if vs[i] == nil || vs[i].(int) != (n+i)*(n+i) {
+							b.Error("key not match")
+						}
+					}
+					m.DelMany(keys)
+				}
+				wg.Done()
+			}(i*10000, (i+1)*10000)
+		}
+		wg.Wait()
+	}
+}
+
 func BenchmarkMapAndRWMutex(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		m := make(map[int]int)
@@ -143,3 +264,80 @@ func BenchmarkSyncMap(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+func BenchmarkLockfreeHashMapGoroutines(b *testing.B) {
+	const keys = 1 << 16
+	var (
+		m    = NewMap[int, int]()
+		rw   = make(map[int]int, keys)
+		lock sync.RWMutex
+		sm   sync.Map
+	)
+	for i := 0; i < keys; i++ {
+		m.Set(i, i)
+		rw[i] = i
+		sm.Store(i, i)
+	}
+
+	// the same load on a map with RWMutex and on sync.Map as the baselines
+	for _, c := range []struct {
+		name string
+		get  func(int) bool
+		set  func(int, int)
+	}{
+		{
+			"lockfree",
+			func(k int) bool {
+				_, ok := m.Get(k)
+				return ok
+			},
+			m.Set,
+		},
+		{
+			"rwmutex",
+			func(k int) bool {
+				lock.RLock()
+				_, ok := rw[k]
+				lock.RUnlock()
+				return ok
+			},
+			func(k, v int) {
+				lock.Lock()
+				rw[k] = v
+				lock.Unlock()
+			},
+		},
+		{
+			"syncmap",
+			func(k int) bool {
+				_, ok := sm.Load(k)
+				return ok
+			},
+			func(k, v int) {
+				sm.Store(k, v)
+			},
+		},
+	} {
+		// 90% Get and 10% Set over a fixed key set, so the map does not resize
+		for _, n := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("%s/goroutines-%d", c.name, n), func(b *testing.B) {
+				wg := sync.WaitGroup{}
+				wg.Add(n)
+				for g := 0; g < n; g++ {
+					go func(g int) {
+						for i := g; i < b.N; i += n {
+							k := (i * 7919) & (keys - 1)
+							if i%10 == 0 {
+								c.set(k, k)
+							} else if !c.get(k) {
+								b.Error("key not exist")
+							}
+						}
+						wg.Done()
+					}(g)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics exports the length and counters of registered lockfree
+// containers through expvar, and in Prometheus text format over HTTP
+package metrics
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/dustinxie/lockfree/hashmap"
+	"github.com/dustinxie/lockfree/list"
+)
+
+type (
+	// HashMap is a map to be registered, both lockfree.HashMap and
+	// lockfree.Map[K, V] are HashMap
+	HashMap interface {
+		Len() int
+		Counters() hashmap.Counters
+	}
+
+	// List is a queue or stack to be registered
+	List interface {
+		Len() int
+		Counters() list.Counters
+	}
+
+	// Registry holds the named containers to be exported
+	Registry struct {
+		mutex  sync.RWMutex
+		maps   map[string]HashMap
+		queues map[string]List
+		stacks map[string]List
+	}
+
+	// Snapshot is the metrics of all containers in a registry, keyed by name
+	Snapshot struct {
+		HashMaps map[string]MapMetrics  `json:"hashmaps"`
+		Queues   map[string]ListMetrics `json:"queues"`
+		Stacks   map[string]ListMetrics `json:"stacks"`
+	}
+
+	// MapMetrics is the metrics of a map
+	MapMetrics struct {
+		Len           int     `json:"len"`
+		Gets          uint64  `json:"gets"`
+		Sets          uint64  `json:"sets"`
+		Dels          uint64  `json:"dels"`
+		CASRetries    uint64  `json:"cas_retries"`
+		Expands       uint64  `json:"expands"`
+		Shrinks       uint64  `json:"shrinks"`
+		ResizeSeconds float64 `json:"resize_seconds"`
+	}
+
+	// ListMetrics is the metrics of a queue or stack
+	ListMetrics struct {
+		Len        int    `json:"len"`
+		Adds       uint64 `json:"adds"`
+		Removes    uint64 `json:"removes"`
+		CASRetries uint64 `json:"cas_retries"`
+	}
+)
+
+// Default is the registry used by the package-level functions, it is published
+// to expvar as "lockfree"
+var Default = NewRegistry()
+
+func init() {
+	Default.Publish("lockfree")
+}
+
+// NewRegistry creates a new registry
+func NewRegistry() *Registry {
+	return &Registry{
+		maps:   make(map[string]HashMap),
+		queues: make(map[string]List),
+		stacks: make(map[string]List),
+	}
+}
+
+// RegisterHashMap adds the map to the default registry
+func RegisterHashMap(name string, m HashMap) {
+	Default.RegisterHashMap(name, m)
+}
+
+// RegisterQueue adds the queue to the default registry
+func RegisterQueue(name string, q List) {
+	Default.RegisterQueue(name, q)
+}
+
+// RegisterStack adds the stack to the default registry
+func RegisterStack(name string, s List) {
+	Default.RegisterStack(name, s)
+}
+
+// Unregister removes the containers of the name from the default registry
+func Unregister(name string) {
+	Default.Unregister(name)
+}
+
+// RegisterHashMap adds the map under the name, which replaces the map
+// registered before under the same name
+func (r *Registry) RegisterHashMap(name string, m HashMap) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maps[name] = m
+}
+
+// RegisterQueue adds the queue under the name, which replaces the queue
+// registered before under the same name
+func (r *Registry) RegisterQueue(name string, q List) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.queues[name] = q
+}
+
+// RegisterStack adds the stack under the name, which replaces the stack
+// registered before under the same name
+func (r *Registry) RegisterStack(name string, s List) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stacks[name] = s
+}
+
+// Unregister removes the map, queue and stack of the name
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.maps, name)
+	delete(r.queues, name)
+	delete(r.stacks, name)
+}
+
+// Snapshot reads the metrics of all registered containers. It only reads the
+// counters, so it is cheap enough to be polled
+func (r *Registry) Snapshot() Snapshot {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	snap := Snapshot{
+		HashMaps: make(map[string]MapMetrics, len(r.maps)),
+		Queues:   make(map[string]ListMetrics, len(r.queues)),
+		Stacks:   make(map[string]ListMetrics, len(r.stacks)),
+	}
+	for name, m := range r.maps {
+		c := m.Counters()
+		snap.HashMaps[name] = MapMetrics{
+			Len:           m.Len(),
+			Gets:          c.Gets,
+			Sets:          c.Sets,
+			Dels:          c.Dels,
+			CASRetries:    c.CASRetries,
+			Expands:       c.Expands,
+			Shrinks:       c.Shrinks,
+			ResizeSeconds: c.ResizeTime.Seconds(),
+		}
+	}
+	for name, q := range r.queues {
+		snap.Queues[name] = listMetrics(q)
+	}
+	for name, s := range r.stacks {
+		snap.Stacks[name] = listMetrics(s)
+	}
+	return snap
+}
+
+// Publish exports the snapshot of the registry to expvar under the name. Like
+// expvar.Publish, it panics if the name is already in use
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Snapshot()
+	}))
+}
+
+func listMetrics(l List) ListMetrics {
+	c := l.Counters()
+	return ListMetrics{
+		Len:        l.Len(),
+		Adds:       c.Adds,
+		Removes:    c.Removes,
+		CASRetries: c.CASRetries,
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dustinxie/lockfree"
+	"github.com/dustinxie/lockfree/hashmap"
+	"github.com/dustinxie/lockfree/list"
+)
+
+func TestRegistry(t *testing.T) {
+	req := require.New(t)
+
+	r := NewRegistry()
+	m := lockfree.NewHashMap(hashmap.CountersOption())
+	tm := lockfree.NewMap[string, int](hashmap.CountersOption())
+	q := lockfree.NewQueue(list.CountersOption())
+	s := lockfree.NewStack()
+	r.RegisterHashMap("users", m)
+	r.RegisterHashMap("typed", tm)
+	r.RegisterQueue("jobs", q)
+	r.RegisterStack("undo", s)
+
+	m.Set(1, 1)
+	m.Set(2, 2)
+	m.Get(1)
+	m.Del(2)
+	tm.Set("a", 1)
+	q.Enque(1)
+	q.Enque(2)
+	q.Deque()
+	s.Push(1)
+
+	snap := r.Snapshot()
+	req.Equal(MapMetrics{Len: 1, Gets: 1, Sets: 2, Dels: 1}, snap.HashMaps["users"])
+	req.Equal(MapMetrics{Len: 1, Sets: 1}, snap.HashMaps["typed"])
+	req.Equal(ListMetrics{Len: 1, Adds: 2, Removes: 1}, snap.Queues["jobs"])
+	req.Equal(ListMetrics{Len: 1}, snap.Stacks["undo"])
+
+	r.Unregister("typed")
+	r.Unregister("undo")
+	snap = r.Snapshot()
+	req.Len(snap.HashMaps, 1)
+	req.Len(snap.Queues, 1)
+	req.Empty(snap.Stacks)
+}
+
+func TestExpvar(t *testing.T) {
+	req := require.New(t)
+
+	q := lockfree.NewQueue(list.CountersOption())
+	RegisterQueue("expvar", q)
+	defer Unregister("expvar")
+	q.Enque(1)
+
+	v := expvar.Get("lockfree")
+	req.NotNil(v)
+	var snap Snapshot
+	req.NoError(json.Unmarshal([]byte(v.String()), &snap))
+	req.Equal(ListMetrics{Len: 1, Adds: 1}, snap.Queues["expvar"])
+}
+
+func TestHandler(t *testing.T) {
+	req := require.New(t)
+
+	r := NewRegistry()
+	m := lockfree.NewHashMap(hashmap.CountersOption())
+	r.RegisterHashMap(`a"b`, m)
+	r.RegisterHashMap("users", m)
+	s := lockfree.NewStack(list.CountersOption())
+	r.RegisterStack("undo", s)
+	m.Set(1, 1)
+	s.Push(1)
+	s.Push(2)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	req.Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# HELP lockfree_hashmap_len Number of entries in the map.\n" +
+			"# TYPE lockfree_hashmap_len gauge\n" +
+			`lockfree_hashmap_len{name="a\"b"} 1` + "\n" +
+			`lockfree_hashmap_len{name="users"} 1` + "\n",
+		"# TYPE lockfree_hashmap_sets_total counter\n",
+		`lockfree_hashmap_sets_total{name="users"} 1` + "\n",
+		`lockfree_hashmap_gets_total{name="users"} 0` + "\n",
+		`lockfree_stack_len{name="undo"} 2` + "\n",
+		`lockfree_stack_adds_total{name="undo"} 2` + "\n",
+	} {
+		req.Contains(body, line)
+	}
+	req.NotContains(body, "lockfree_queue")
+	req.Equal(8+4, strings.Count(body, "# TYPE"))
+}
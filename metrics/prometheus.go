@@ -0,0 +1,107 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// family is a Prometheus metric with one sample per container
+	family[T any] struct {
+		name  string
+		help  string
+		typ   string
+		value func(T) float64
+	}
+)
+
+var (
+	mapFamilies = []family[MapMetrics]{
+		{"lockfree_hashmap_len", "Number of entries in the map.", "gauge", func(m MapMetrics) float64 { return float64(m.Len) }},
+		{"lockfree_hashmap_gets_total", "Number of Get calls.", "counter", func(m MapMetrics) float64 { return float64(m.Gets) }},
+		{"lockfree_hashmap_sets_total", "Number of writes.", "counter", func(m MapMetrics) float64 { return float64(m.Sets) }},
+		{"lockfree_hashmap_dels_total", "Number of deletes.", "counter", func(m MapMetrics) float64 { return float64(m.Dels) }},
+		{"lockfree_hashmap_cas_retries_total", "Number of failed CAS when storing an entry.", "counter", func(m MapMetrics) float64 { return float64(m.CASRetries) }},
+		{"lockfree_hashmap_expands_total", "Number of times the buckets doubled.", "counter", func(m MapMetrics) float64 { return float64(m.Expands) }},
+		{"lockfree_hashmap_shrinks_total", "Number of times the buckets halved.", "counter", func(m MapMetrics) float64 { return float64(m.Shrinks) }},
+		{"lockfree_hashmap_resize_seconds_total", "Time spent resizing the buckets.", "counter", func(m MapMetrics) float64 { return m.ResizeSeconds }},
+	}
+
+	queueFamilies = listFamilies("queue", "Enque", "Deque")
+	stackFamilies = listFamilies("stack", "Push", "Pop")
+
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+func listFamilies(kind, add, remove string) []family[ListMetrics] {
+	prefix := "lockfree_" + kind + "_"
+	return []family[ListMetrics]{
+		{prefix + "len", "Number of items in the " + kind + ".", "gauge", func(l ListMetrics) float64 { return float64(l.Len) }},
+		{prefix + "adds_total", "Number of " + add + " calls.", "counter", func(l ListMetrics) float64 { return float64(l.Adds) }},
+		{prefix + "removes_total", "Number of items removed by " + remove + ".", "counter", func(l ListMetrics) float64 { return float64(l.Removes) }},
+		{prefix + "cas_retries_total", "Number of failed CAS when adding or removing an item.", "counter", func(l ListMetrics) float64 { return float64(l.CASRetries) }},
+	}
+}
+
+// Handler serves the default registry in Prometheus text format
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Handler serves the registry in Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes the metrics of the registry in Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	var (
+		snap = r.Snapshot()
+		bw   = bufio.NewWriter(w)
+	)
+	writeFamilies(bw, mapFamilies, snap.HashMaps)
+	writeFamilies(bw, queueFamilies, snap.Queues)
+	writeFamilies(bw, stackFamilies, snap.Stacks)
+	return bw.Flush()
+}
+
+// writeFamilies writes each family with a sample per container, sorted by name
+func writeFamilies[T any](w *bufio.Writer, families []family[T], metrics map[string]T) {
+	if len(metrics) == 0 {
+		return
+	}
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, f := range families {
+		w.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		for _, name := range names {
+			w.WriteString(f.name + `{name="` + labelEscaper.Replace(name) + `"} `)
+			w.WriteString(strconv.FormatFloat(f.value(metrics[name]), 'g', -1, 64) + "\n")
+		}
+	}
+}
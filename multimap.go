@@ -0,0 +1,53 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockfree
+
+import (
+	"iter"
+
+	"github.com/dustinxie/lockfree/hashmap"
+)
+
+type (
+	// MultiMap maps a key to a set of values
+	MultiMap[K comparable, V comparable] interface {
+		// number of keys
+		Len() int
+
+		// adds the value to the key, returns true if it is newly added
+		Put(key K, value V) bool
+
+		// removes the value from the key, returns true if it was there. The
+		// key is deleted with its last value
+		Remove(key K, value V) bool
+
+		// deletes the key and returns its values
+		RemoveAll(key K) []V
+
+		// returns a copy of the values of the key
+		Get(key K) []V
+
+		// returns true if the value is one of the values of the key
+		ContainsEntry(key K, value V) bool
+
+		// for k, values := range m.All()
+		All() iter.Seq2[K, []V]
+	}
+)
+
+// NewMultiMap creates a new multimap, which takes the options of NewMap
+func NewMultiMap[K comparable, V comparable](opts ...hashmap.Option) MultiMap[K, V] {
+	return hashmap.NewMultiMap[K, V](opts...)
+}
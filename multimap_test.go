@@ -0,0 +1,52 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockfree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMultiMap(t *testing.T) {
+	req := require.New(t)
+
+	// 4 handlers register and unregister for the same routes
+	type handler int
+	mm := NewMultiMap[string, handler]()
+	routes := []string{"/a", "/b", "/c"}
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	for i := 0; i < 4; i++ {
+		go func(h handler) {
+			for _, r := range routes {
+				mm.Put(r, h)
+				mm.Put(r, h+10)
+			}
+			for _, r := range routes {
+				mm.Remove(r, h+10)
+			}
+			wg.Done()
+		}(handler(i))
+	}
+	wg.Wait()
+	for _, r := range routes {
+		req.ElementsMatch([]handler{0, 1, 2, 3}, mm.Get(r))
+		req.False(mm.ContainsEntry(r, 10))
+	}
+	req.Len(mm.RemoveAll("/a"), 4)
+	req.Equal(2, mm.Len())
+}
@@ -29,10 +29,13 @@ type (
 
 		// remove an item from the queue
 		Deque() interface{}
+
+		// returns the running totals of the list
+		Counters() list.Counters
 	}
 )
 
 // NewQueue creates a new queue
-func NewQueue() Queue {
-	return list.NewQueue()
+func NewQueue(opts ...list.Option) Queue {
+	return list.NewQueue(opts...)
 }
@@ -0,0 +1,93 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockfree
+
+import (
+	"iter"
+
+	"github.com/dustinxie/lockfree/hashmap"
+)
+
+type (
+	// Set is a set of keys
+	Set[K comparable] interface {
+		// len(set)
+		Len() int
+
+		// adds the key, returns true if it is newly added
+		Add(key K) bool
+
+		// returns true if the key is in the set
+		Contains(key K) bool
+
+		// removes the key, returns true if it was in the set
+		Remove(key K) bool
+
+		// removes all keys at once
+		Clear()
+
+		// for k := range s.All()
+		All() iter.Seq[K]
+
+		// for k := range set { if !f(k) { break } }
+		Range(f func(key K) bool)
+
+		// returns a new set of the keys in either set
+		Union(other Set[K]) Set[K]
+
+		// returns a new set of the keys in both sets
+		Intersect(other Set[K]) Set[K]
+
+		// returns a new set of the keys in this set but not in other
+		Difference(other Set[K]) Set[K]
+
+		// returns true if every key in this set is in other
+		IsSubset(other Set[K]) bool
+
+		// returns the underlying set, only sets made by NewSet are accepted
+		// by the set operations
+		set() *hashmap.Set[K]
+	}
+
+	// hashSet is a thin wrapper that takes and returns sets as interface
+	hashSet[K comparable] struct {
+		*hashmap.Set[K]
+	}
+)
+
+// NewSet creates a new set, which takes the options of NewMap
+func NewSet[K comparable](opts ...hashmap.Option) Set[K] {
+	return hashSet[K]{hashmap.NewSet[K](opts...)}
+}
+
+func (s hashSet[K]) set() *hashmap.Set[K] {
+	return s.Set
+}
+
+func (s hashSet[K]) Union(other Set[K]) Set[K] {
+	return hashSet[K]{s.Set.Union(other.set())}
+}
+
+func (s hashSet[K]) Intersect(other Set[K]) Set[K] {
+	return hashSet[K]{s.Set.Intersect(other.set())}
+}
+
+func (s hashSet[K]) Difference(other Set[K]) Set[K] {
+	return hashSet[K]{s.Set.Difference(other.set())}
+}
+
+func (s hashSet[K]) IsSubset(other Set[K]) bool {
+	return s.Set.IsSubset(other.set())
+}
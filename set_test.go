@@ -0,0 +1,63 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockfree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSet(t *testing.T) {
+	req := require.New(t)
+
+	s := NewSet[int]()
+	// test 4 threads, each adds 2/3 of the keys it removes
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	for i := 0; i < 4; i++ {
+		go func(start, end int) {
+			for i := start; i < end; i++ {
+				if !s.Add(i) || s.Add(i) || !s.Contains(i) {
+					t.Errorf("key %d not added", i)
+				}
+			}
+			for i := start; i < end; i += 3 {
+				if !s.Remove(i) {
+					t.Errorf("key %d not removed", i)
+				}
+			}
+			wg.Done()
+		}(i*3000, (i+1)*3000)
+	}
+	wg.Wait()
+	req.Equal(8000, s.Len())
+
+	odd := NewSet[int]()
+	for i := 1; i < 12000; i += 2 {
+		odd.Add(i)
+	}
+	req.Equal(12000-2000, s.Union(odd).Len())
+	both := s.Intersect(odd)
+	req.Equal(4000, both.Len())
+	req.True(both.IsSubset(s))
+	req.True(both.IsSubset(odd))
+	req.False(s.IsSubset(odd))
+	for k := range s.Difference(odd).All() {
+		req.Zero(k % 2)
+		req.NotZero(k % 3)
+	}
+}
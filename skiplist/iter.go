@@ -0,0 +1,85 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"iter"
+)
+
+// All returns an iterator over <k, v> in ascending order of keys. It does not
+// lock the map, and is weakly consistent like sync.Map.Range: each key is
+// returned at most once, and entries set or deleted during the walk may or may
+// not be seen
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.ascend(m.base.next(), nil, yield)
+	}
+}
+
+// Range returns an iterator over <k, v> with from <= k < to in ascending order,
+// consistent like All
+func (m *Map[K, V]) Range(from, to K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.ascend(m.findNear(from, gt|eq), &to, yield)
+	}
+}
+
+// Backward returns an iterator over <k, v> in descending order of keys. Each
+// step searches for the next lower key, so it is slower than All
+func (m *Map[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v, ok := m.Last(); ok && yield(k, v); k, v, ok = m.Lower(k) {
+		}
+	}
+}
+
+// RangeBackward returns an iterator over <k, v> with from <= k < to in
+// descending order, like Backward
+func (m *Map[K, V]) RangeBackward(from, to K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v, ok := m.Lower(to); ok && m.cmp(k, from) >= 0 && yield(k, v); k, v, ok = m.Lower(k) {
+		}
+	}
+}
+
+// Keys returns an iterator over keys in ascending order
+func (m *Map[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// ascend walks the base list from n, and stops before the key to if it is not
+// nil. A deleted node keeps its link to the marker and then the rest of the
+// list, so the walk always moves forward
+func (m *Map[K, V]) ascend(n *node, to *K, yield func(K, V) bool) {
+	for ; n != nil; n = n.next() {
+		v := n.value()
+		if v == nil || v == markerVal {
+			continue
+		}
+		k := m.key(n)
+		if to != nil && m.cmp(k, *to) >= 0 {
+			return
+		}
+		if !yield(k, *(*V)(v)) {
+			return
+		}
+	}
+}
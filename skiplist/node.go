@@ -0,0 +1,103 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+type (
+	// node is a node of the base list, which holds all keys in order. It is
+	// deleted like hashmap's node: its value is set to nil, then a marker is
+	// linked after it, then it is unlinked
+	node struct {
+		key unsafe.Pointer // *K, nil for the head and markers
+		val unsafe.Pointer // *V, nil once deleted
+		nxt unsafe.Pointer // *node
+	}
+
+	// index is a node of an upper level, which skips over the base list. An
+	// index of a deleted node is unlinked by whoever passes it, and an index
+	// lost to a race only makes searches longer
+	index struct {
+		node  *node
+		down  *index
+		right unsafe.Pointer // *index
+	}
+
+	// head is the first index of the top level
+	head struct {
+		index
+		level int // number of index levels, 1 at the lowest
+	}
+)
+
+var (
+	// markerVal is the value of marker nodes
+	markerVal = unsafe.Pointer(new(byte))
+
+	// headVal is the value of the head node, which is never deleted
+	headVal = unsafe.Pointer(new(byte))
+)
+
+// isMarker returns true if n is the marker following a deleted node
+func (n *node) isMarker() bool {
+	return n.value() == markerVal
+}
+
+func (n *node) next() *node {
+	return (*node)(atomic.LoadPointer(&n.nxt))
+}
+
+func (n *node) value() unsafe.Pointer {
+	return atomic.LoadPointer(&n.val)
+}
+
+func (n *node) casNext(expected, target *node) bool {
+	return atomic.CompareAndSwapPointer(&n.nxt, unsafe.Pointer(expected), unsafe.Pointer(target))
+}
+
+func (n *node) casValue(expected, target unsafe.Pointer) bool {
+	return atomic.CompareAndSwapPointer(&n.val, expected, target)
+}
+
+// appendMarker links a marker between n and its successor f, so that CAS on
+// its next pointer fails from now on and n can be safely unlinked
+func (n *node) appendMarker(f *node) bool {
+	return n.casNext(f, &node{val: markerVal, nxt: unsafe.Pointer(f)})
+}
+
+// helpDelete finishes the deletion of n, whose value is nil, by marking it or
+// unlinking it from its predecessor b. f is the successor of n
+func (n *node) helpDelete(b, f *node) {
+	if f != n.next() || n != b.next() {
+		// changed meanwhile, the caller retries
+		return
+	}
+	if f == nil || !f.isMarker() {
+		n.appendMarker(f)
+	} else {
+		b.casNext(n, f.next())
+	}
+}
+
+func (q *index) loadRight() *index {
+	return (*index)(atomic.LoadPointer(&q.right))
+}
+
+func (q *index) casRight(expected, target *index) bool {
+	return atomic.CompareAndSwapPointer(&q.right, unsafe.Pointer(expected), unsafe.Pointer(target))
+}
@@ -0,0 +1,445 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"math/bits"
+	"math/rand/v2"
+	"sync/atomic"
+	"unsafe"
+)
+
+// maxLevel caps the index levels, enough for 4^32 keys
+const maxLevel = 32
+
+// relations of the key to look for in findNear
+const (
+	gt = 0 // the smallest key > the given key
+	eq = 1 // the key itself, combined with gt or lt
+	lt = 2 // the largest key < the given key
+)
+
+type (
+	// Map is a concurrent sorted map[K]V, a lock-free skiplist ordered by a
+	// comparator
+	Map[K, V any] struct {
+		cmp   func(a, b K) int // < 0, 0 or > 0 if a is less than, equal to or greater than b
+		base  node             // head of the base list
+		top   unsafe.Pointer   // *head of the top level
+		count int64
+	}
+)
+
+// New creates a new sorted map ordered by cmp, which returns < 0, 0 or > 0 if
+// a is less than, equal to or greater than b, like cmp.Compare
+func New[K, V any](cmp func(a, b K) int) *Map[K, V] {
+	m := Map[K, V]{cmp: cmp}
+	m.base.val = headVal
+	m.top = unsafe.Pointer(&head{
+		index: index{node: &m.base},
+		level: 1,
+	})
+	return &m
+}
+
+func (m *Map[K, V]) Len() int {
+	return int(atomic.LoadInt64(&m.count))
+}
+
+func (m *Map[K, V]) Get(key K) (V, bool) {
+	for {
+		_, n, v, found := m.seek(key)
+		if !found {
+			var v V
+			return v, false
+		}
+		if n.value() == v {
+			return *(*V)(v), true
+		}
+		// updated or deleted since
+	}
+}
+
+func (m *Map[K, V]) Set(key K, value V) {
+	m.put(key, value, false)
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored
+func (m *Map[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	if v, loaded := m.put(key, value, true); loaded {
+		return v, true
+	}
+	return value, false
+}
+
+func (m *Map[K, V]) Del(key K) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete deletes the value for a key, and returns the previous value if
+// any. The loaded result reports whether the key was present
+func (m *Map[K, V]) LoadAndDelete(key K) (V, bool) {
+	for {
+		b, n, v, found := m.seek(key)
+		if !found {
+			var v V
+			return v, false
+		}
+		if !n.casValue(v, nil) {
+			continue
+		}
+		atomic.AddInt64(&m.count, -1)
+		if f := n.next(); !n.appendMarker(f) || !b.casNext(n, f) {
+			// let seek finish the deletion
+			m.seek(key)
+		} else {
+			// unlink the index of n
+			m.findPredecessor(key)
+		}
+		return *(*V)(v), true
+	}
+}
+
+// put stores the value, or only returns the existing value if onlyIfAbsent is
+// true. It returns the previous value and whether the key was present
+func (m *Map[K, V]) put(key K, value V, onlyIfAbsent bool) (V, bool) {
+	val := unsafe.Pointer(&value)
+	for {
+		b, n, v, found := m.seek(key)
+		if found {
+			if onlyIfAbsent || n.casValue(v, val) {
+				return *(*V)(v), true
+			}
+			continue
+		}
+		z := &node{key: unsafe.Pointer(&key), val: val, nxt: unsafe.Pointer(n)}
+		if !b.casNext(n, z) {
+			continue
+		}
+		atomic.AddInt64(&m.count, 1)
+		m.addIndex(z, key)
+		var old V
+		return old, false
+	}
+}
+
+// First returns the smallest key and its value, false if the map is empty
+func (m *Map[K, V]) First() (K, V, bool) {
+	for {
+		n := m.base.next()
+		if n == nil {
+			var (
+				k K
+				v V
+			)
+			return k, v, false
+		}
+		if v := n.value(); v != nil {
+			return m.key(n), *(*V)(v), true
+		}
+		n.helpDelete(&m.base, n.next())
+	}
+}
+
+// Last returns the largest key and its value, false if the map is empty
+func (m *Map[K, V]) Last() (K, V, bool) {
+	for {
+		n := m.findLast()
+		if n == nil {
+			var (
+				k K
+				v V
+			)
+			return k, v, false
+		}
+		if v := n.value(); v != nil {
+			return m.key(n), *(*V)(v), true
+		}
+	}
+}
+
+// Floor returns the largest key <= the given key and its value, false if
+// there is none
+func (m *Map[K, V]) Floor(key K) (K, V, bool) {
+	return m.getNear(key, lt|eq)
+}
+
+// Ceiling returns the smallest key >= the given key and its value, false if
+// there is none
+func (m *Map[K, V]) Ceiling(key K) (K, V, bool) {
+	return m.getNear(key, gt|eq)
+}
+
+// Lower returns the largest key < the given key and its value, false if there
+// is none
+func (m *Map[K, V]) Lower(key K) (K, V, bool) {
+	return m.getNear(key, lt)
+}
+
+// Higher returns the smallest key > the given key and its value, false if
+// there is none
+func (m *Map[K, V]) Higher(key K) (K, V, bool) {
+	return m.getNear(key, gt)
+}
+
+func (m *Map[K, V]) getNear(key K, rel int) (K, V, bool) {
+	for {
+		n := m.findNear(key, rel)
+		if n == nil {
+			var (
+				k K
+				v V
+			)
+			return k, v, false
+		}
+		if v := n.value(); v != nil {
+			return m.key(n), *(*V)(v), true
+		}
+	}
+}
+
+func (m *Map[K, V]) head() *head {
+	return (*head)(atomic.LoadPointer(&m.top))
+}
+
+func (m *Map[K, V]) key(n *node) K {
+	return *(*K)(n.key)
+}
+
+// findPredecessor returns a node with a key less than the given key, or the
+// head, by walking the index levels down to the base list. Indexes of deleted
+// nodes passed on the way are unlinked
+func (m *Map[K, V]) findPredecessor(key K) *node {
+retry:
+	for {
+		q := &m.head().index
+		r := q.loadRight()
+		for {
+			if r != nil {
+				if r.node.value() == nil {
+					// the node is deleted, unlink its index
+					if !q.casRight(r, r.loadRight()) {
+						continue retry
+					}
+					r = q.loadRight()
+					continue
+				}
+				if m.cmp(key, m.key(r.node)) > 0 {
+					q, r = r, r.loadRight()
+					continue
+				}
+			}
+			if q.down == nil {
+				return q.node
+			}
+			q = q.down
+			r = q.loadRight()
+		}
+	}
+}
+
+// seek returns b and n, where b links to n and both are live, and n is the
+// node of the key if found is true, otherwise the first node with a larger key
+// (nil at the end). v is the value of n. Deleted nodes passed on the way are
+// unlinked
+func (m *Map[K, V]) seek(key K) (b, n *node, v unsafe.Pointer, found bool) {
+retry:
+	for {
+		b = m.findPredecessor(key)
+		n = b.next()
+		for {
+			if n == nil {
+				return b, nil, nil, false
+			}
+			f := n.next()
+			if n != b.next() {
+				// b has a new successor
+				continue retry
+			}
+			if v = n.value(); v == nil {
+				n.helpDelete(b, f)
+				continue retry
+			}
+			if b.value() == nil || v == markerVal {
+				// b is deleted
+				continue retry
+			}
+			c := m.cmp(key, m.key(n))
+			if c <= 0 {
+				return b, n, v, c == 0
+			}
+			b, n = n, f
+		}
+	}
+}
+
+// findNear returns the node nearest to the key in the given relation, nil if
+// there is none
+func (m *Map[K, V]) findNear(key K, rel int) *node {
+retry:
+	for {
+		b := m.findPredecessor(key)
+		n := b.next()
+		for {
+			if n == nil {
+				if rel&lt == 0 || b == &m.base {
+					return nil
+				}
+				return b
+			}
+			f := n.next()
+			if n != b.next() {
+				continue retry
+			}
+			v := n.value()
+			if v == nil {
+				n.helpDelete(b, f)
+				continue retry
+			}
+			if b.value() == nil || v == markerVal {
+				continue retry
+			}
+			c := m.cmp(key, m.key(n))
+			if c == 0 && rel&eq != 0 || c < 0 && rel&lt == 0 {
+				return n
+			}
+			if c <= 0 && rel&lt != 0 {
+				if b == &m.base {
+					return nil
+				}
+				return b
+			}
+			b, n = n, f
+		}
+	}
+}
+
+// findLast returns the last node, nil if the map is empty
+func (m *Map[K, V]) findLast() *node {
+retry:
+	for {
+		// take the rightmost index on every level
+		q := &m.head().index
+		for {
+			if r := q.loadRight(); r != nil {
+				if r.node.value() == nil {
+					q.casRight(r, r.loadRight())
+					continue retry
+				}
+				q = r
+			} else if q.down != nil {
+				q = q.down
+			} else {
+				break
+			}
+		}
+		b := q.node
+		n := b.next()
+		for {
+			if n == nil {
+				if b == &m.base {
+					return nil
+				}
+				return b
+			}
+			f := n.next()
+			if n != b.next() {
+				continue retry
+			}
+			v := n.value()
+			if v == nil {
+				n.helpDelete(b, f)
+				continue retry
+			}
+			if b.value() == nil || v == markerVal {
+				continue retry
+			}
+			b, n = n, f
+		}
+	}
+}
+
+// addIndex links an index tower of random height for the new node z. Each
+// level has 1/4 of the indexes of the level below
+func (m *Map[K, V]) addIndex(z *node, key K) {
+	level := min(bits.TrailingZeros64(rand.Uint64())/2, maxLevel)
+	if level == 0 {
+		return
+	}
+	h := m.head()
+	// grow the index by at most one level at a time
+	level = min(level, h.level+1)
+	tower := make([]*index, level+1)
+	for i := 1; i <= level; i++ {
+		tower[i] = &index{node: z, down: tower[i-1]}
+	}
+	if level > h.level {
+		top := &head{
+			index: index{node: &m.base, down: &h.index, right: unsafe.Pointer(tower[level])},
+			level: level,
+		}
+		if atomic.CompareAndSwapPointer(&m.top, unsafe.Pointer(h), unsafe.Pointer(top)) {
+			level--
+		} else {
+			// grown by another go-routine meanwhile
+			level = min(level, m.head().level)
+		}
+	}
+	for ; level > 0; level-- {
+		if !m.linkIndex(tower[level], level, key) {
+			return
+		}
+	}
+}
+
+// linkIndex links the index at the given level, and returns false if its node
+// is deleted meanwhile
+func (m *Map[K, V]) linkIndex(idx *index, level int, key K) bool {
+retry:
+	for {
+		if idx.node.value() == nil {
+			return false
+		}
+		h := m.head()
+		q, l := &h.index, h.level
+		r := q.loadRight()
+		for {
+			if r != nil {
+				if r.node.value() == nil {
+					if !q.casRight(r, r.loadRight()) {
+						continue retry
+					}
+					r = q.loadRight()
+					continue
+				}
+				if m.cmp(key, m.key(r.node)) > 0 {
+					q, r = r, r.loadRight()
+					continue
+				}
+			}
+			if l == level {
+				atomic.StorePointer(&idx.right, unsafe.Pointer(r))
+				if !q.casRight(r, idx) {
+					continue retry
+				}
+				return true
+			}
+			q, l = q.down, l-1
+			r = q.loadRight()
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package skiplist
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkiplist(t *testing.T) {
+	req := require.New(t)
+
+	m := New[int, int](cmp.Compare[int])
+	_, _, ok := m.First()
+	req.False(ok)
+	_, _, ok = m.Last()
+	req.False(ok)
+	_, _, ok = m.Floor(0)
+	req.False(ok)
+	req.Empty(slices.Collect(m.Keys()))
+
+	// even keys [0, 2000) in random order
+	ref := make(map[int]int)
+	for _, i := range rand.Perm(1000) {
+		m.Set(2*i, i)
+		ref[2*i] = i
+	}
+	req.Equal(1000, m.Len())
+	req.True(m.head().level > 1)
+	v, loaded := m.LoadOrStore(10, -1)
+	req.True(loaded)
+	req.Equal(5, v)
+	v, loaded = m.LoadOrStore(11, -1)
+	req.False(loaded)
+	req.Equal(-1, v)
+	v, loaded = m.LoadAndDelete(11)
+	req.True(loaded)
+	req.Equal(-1, v)
+	_, loaded = m.LoadAndDelete(11)
+	req.False(loaded)
+
+	// delete 1/3 of the keys
+	for i := 0; i < 2000; i += 6 {
+		m.Del(i)
+		delete(ref, i)
+	}
+	req.Equal(len(ref), m.Len())
+	keys := make([]int, 0, len(ref))
+	for k := range ref {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for i := -1; i <= 2000; i++ {
+		v, ok := m.Get(i)
+		req.Equal(ref[i], v)
+		_, present := ref[i]
+		req.Equal(present, ok)
+
+		// the nearest keys
+		j, _ := slices.BinarySearch(keys, i)
+		near := func(k int, v int, ok bool, at int) {
+			if at < 0 || at >= len(keys) {
+				req.False(ok)
+				return
+			}
+			req.True(ok)
+			req.Equal(keys[at], k)
+			req.Equal(ref[k], v)
+		}
+		k, v, ok := m.Ceiling(i)
+		near(k, v, ok, j)
+		k, v, ok = m.Lower(i)
+		near(k, v, ok, j-1)
+		k, v, ok = m.Floor(i)
+		if present {
+			near(k, v, ok, j)
+		} else {
+			near(k, v, ok, j-1)
+		}
+		k, v, ok = m.Higher(i)
+		if present {
+			near(k, v, ok, j+1)
+		} else {
+			near(k, v, ok, j)
+		}
+	}
+
+	k, v, ok := m.First()
+	req.True(ok)
+	req.Equal(keys[0], k)
+	req.Equal(ref[k], v)
+	k, _, ok = m.Last()
+	req.True(ok)
+	req.Equal(keys[len(keys)-1], k)
+
+	req.Equal(keys, slices.Collect(m.Keys()))
+	var backward []int
+	for k, v := range m.Backward() {
+		req.Equal(ref[k], v)
+		backward = append(backward, k)
+	}
+	slices.Reverse(backward)
+	req.Equal(keys, backward)
+
+	// [101, 501)
+	lo, _ := slices.BinarySearch(keys, 101)
+	hi, _ := slices.BinarySearch(keys, 501)
+	var in []int
+	for k := range m.Range(101, 501) {
+		in = append(in, k)
+	}
+	req.Equal(keys[lo:hi], in)
+	in = in[:0]
+	for k := range m.RangeBackward(101, 501) {
+		in = append(in, k)
+	}
+	slices.Reverse(in)
+	req.Equal(keys[lo:hi], in)
+	for range m.Range(501, 101) {
+		req.Fail("empty range")
+	}
+
+	// stop early
+	for k := range m.Range(100, 2000) {
+		req.Equal(100, k)
+		break
+	}
+	for k := range m.Backward() {
+		req.Equal(keys[len(keys)-1], k)
+		break
+	}
+
+	// custom order
+	desc := New[string, int](func(a, b string) int {
+		return cmp.Compare(b, a)
+	})
+	for _, s := range []string{"b", "c", "a"} {
+		desc.Set(s, 0)
+	}
+	req.Equal([]string{"c", "b", "a"}, slices.Collect(desc.Keys()))
+}
+
+func TestSkiplistConcurrent(t *testing.T) {
+	req := require.New(t)
+
+	m := New[int, int](cmp.Compare[int])
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+
+	// keys [0, 10000) with i%4 == 0 stay, others are deleted and then
+	// [10000, 20000) are added by 4 go-routines, while readers check the
+	// order of keys
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 4; n++ {
+		go func(n int) {
+			defer wg.Done()
+			for i := n; i < 10000; i += 4 {
+				if i%4 != 0 {
+					m.Del(i)
+				}
+			}
+			for i := 10000 + n; i < 20000; i += 4 {
+				m.Set(i, i)
+			}
+		}(n)
+	}
+	for n := 0; n < 4; n++ {
+		go func() {
+			defer wg.Done()
+			prev, stay := -1, 0
+			for k, v := range m.All() {
+				if k != v || k <= prev {
+					t.Errorf("key %d after %d, value %d", k, prev, v)
+				}
+				if k < 10000 && k%4 == 0 {
+					stay++
+				}
+				prev = k
+			}
+			if stay != 2500 {
+				t.Errorf("%d keys stay", stay)
+			}
+			for k := 0; k < 10000; k += 4 {
+				if f, _, ok := m.Floor(k + 3); !ok || f < k || f > k+3 {
+					t.Errorf("floor of %d = %d, %v", k+3, f, ok)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	req.Equal(12500, m.Len())
+	keys := slices.Collect(m.Keys())
+	req.Len(keys, 12500)
+	req.True(slices.IsSorted(keys))
+	k, _, _ := m.Last()
+	req.Equal(19999, k)
+}
+
+func TestSkiplistContended(t *testing.T) {
+	req := require.New(t)
+
+	// go-routines set and delete the same 64 keys
+	m := New[int, int](cmp.Compare[int])
+	var wg sync.WaitGroup
+	wg.Add(8)
+	for n := 0; n < 8; n++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				k := rand.IntN(64)
+				if rand.IntN(2) == 0 {
+					m.Set(k, k)
+				} else {
+					m.Del(k)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	keys := slices.Collect(m.Keys())
+	req.True(slices.IsSorted(keys))
+	req.Len(slices.Compact(slices.Clone(keys)), len(keys))
+	req.Equal(len(keys), m.Len())
+	for k := 0; k < 64; k++ {
+		_, ok := m.Get(k)
+		_, found := slices.BinarySearch(keys, k)
+		req.Equal(found, ok)
+	}
+}
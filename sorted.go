@@ -0,0 +1,86 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockfree
+
+import (
+	"iter"
+
+	"github.com/dustinxie/lockfree/skiplist"
+)
+
+type (
+	// SortedMap is a map[K]V ordered by a comparator
+	SortedMap[K, V any] interface {
+		// len(map)
+		Len() int
+
+		// v, ok := map[key]
+		Get(key K) (V, bool)
+
+		// map[key] = value
+		Set(key K, value V)
+
+		// delete(map, key)
+		Del(key K)
+
+		// returns the existing value if present, otherwise stores and returns
+		// the given value. loaded is true if the value was loaded
+		LoadOrStore(key K, value V) (actual V, loaded bool)
+
+		// deletes the key and returns its previous value if any
+		LoadAndDelete(key K) (value V, loaded bool)
+
+		// returns the smallest key
+		First() (K, V, bool)
+
+		// returns the largest key
+		Last() (K, V, bool)
+
+		// returns the largest key <= key
+		Floor(key K) (K, V, bool)
+
+		// returns the smallest key >= key
+		Ceiling(key K) (K, V, bool)
+
+		// returns the largest key < key
+		Lower(key K) (K, V, bool)
+
+		// returns the smallest key > key
+		Higher(key K) (K, V, bool)
+
+		// for k, v := range m.All() in ascending order
+		All() iter.Seq2[K, V]
+
+		// for k, v := range m.Backward() in descending order
+		Backward() iter.Seq2[K, V]
+
+		// for k, v := range m.Range(from, to), from <= k < to in ascending
+		// order
+		Range(from, to K) iter.Seq2[K, V]
+
+		// for k, v := range m.RangeBackward(from, to), from <= k < to in
+		// descending order
+		RangeBackward(from, to K) iter.Seq2[K, V]
+
+		// for k := range m.Keys() in ascending order
+		Keys() iter.Seq[K]
+	}
+)
+
+// NewSortedMap creates a new sorted map ordered by cmp, which returns < 0, 0 or
+// > 0 if a is less than, equal to or greater than b, like cmp.Compare
+func NewSortedMap[K, V any](cmp func(a, b K) int) SortedMap[K, V] {
+	return skiplist.New[K, V](cmp)
+}
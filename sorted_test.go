@@ -0,0 +1,90 @@
+// Copyright 2021 dustinxie
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockfree
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortedMap(t *testing.T) {
+	req := require.New(t)
+
+	m := NewSortedMap[int, int](cmp.Compare[int])
+	// test 4 threads
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	for i := 0; i < 4; i++ {
+		go func(start, end int) {
+			for i := start; i < end; i++ {
+				m.Set(i, i*i)
+			}
+			for i := start; i < end; i += 2 {
+				m.Del(i)
+			}
+			wg.Done()
+		}(i*10000, (i+1)*10000)
+	}
+	wg.Wait()
+	req.Equal(20000, m.Len())
+
+	k, v, ok := m.Ceiling(100)
+	req.True(ok)
+	req.Equal(101, k)
+	req.Equal(101*101, v)
+	k, _, _ = m.Floor(100)
+	req.Equal(99, k)
+	k, _, _ = m.Last()
+	req.Equal(39999, k)
+	var keys []int
+	for k := range m.Range(10, 20) {
+		keys = append(keys, k)
+	}
+	req.Equal([]int{11, 13, 15, 17, 19}, keys)
+	req.True(slices.IsSorted(slices.Collect(m.Keys())))
+}
+
+func BenchmarkLockfreeSortedMap(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		m := NewSortedMap[int, int](cmp.Compare[int])
+		wg := sync.WaitGroup{}
+		wg.Add(10)
+		for i := 0; i < 10; i++ {
+			go func(start, end int) {
+				for i := start; i < end; i++ {
+					m.Set(i, i*i)
+				}
+				for i := start; i < end; i++ {
+					v, ok := m.Get(i)
+					if !ok {
+						b.Error("key not exist")
+					}
+					if v != i*i {
+						b.Error("key not match")
+					}
+				}
+				for i := start; i < end; i++ {
+					m.Del(i)
+				}
+				wg.Done()
+			}(i*10000, (i+1)*10000)
+		}
+		wg.Wait()
+	}
+}
@@ -32,10 +32,13 @@ type (
 
 		// return (but not remove) the top item on the stack
 		Peek() interface{}
+
+		// returns the running totals of the list
+		Counters() list.Counters
 	}
 )
 
 // NewStack creates a new stack
-func NewStack() Stack {
-	return list.NewStack()
+func NewStack(opts ...list.Option) Stack {
+	return list.NewStack(opts...)
 }